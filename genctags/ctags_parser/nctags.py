@@ -228,7 +228,7 @@ class TypeDaggerNode(DaggerNode):
 # This list is used to filter out the structs that we are interested in
 # When adding a new struct to the list, if the struct contains a non-pointer member of a struct type,
 # that struct should also be added to the list.  
-structlist = ['PyConfig', 'PyPreConfig', 'PyMethodDef', 'PyModuleDef', 'PyTypeObject', 'PyObject', 'PyMemberDef', 'PyGetSetDef', 'PyStructSequence_Desc']
+structlist = ['PyConfig', 'PyPreConfig', 'PyMethodDef', 'PyModuleDef', 'PyTypeObject', 'PyObject', 'PyMemberDef', 'PyGetSetDef', 'PyStructSequence_Desc', 'Py_complex']
 
 # intrinsic types and the sizes for 64-bit systems
 intrinsic_types = {
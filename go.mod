@@ -1,11 +1,12 @@
 module github.com/richinsley/kindalib
 
-go 1.22.1
+go 1.23
 
 require (
-	github.com/ebitengine/purego v0.7.1
+	github.com/ebitengine/purego v0.10.2
 	github.com/go-git/go-git/v5 v5.11.0
 	github.com/richinsley/kinda v0.1.0
+	golang.org/x/sys v0.20.0
 )
 
 require (
@@ -30,7 +31,6 @@ require (
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/net v0.21.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/term v0.20.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
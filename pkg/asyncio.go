@@ -0,0 +1,433 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+)
+
+// asyncFunc marks a function passed to Async
+type asyncFunc struct {
+	fn any
+}
+
+// Async marks a function passed to a ModuleBuilder Func or a ClassBuilder Method as
+// asynchronous: calling it from a coroutine converts the arguments and returns an
+// asyncio.Future of the running event loop, and the Go function runs in a goroutine, so long
+// Go work doesn't block the loop.  The result is converted and the future completed on the
+// loop thread, through loop.call_soon_threadsafe.  A context.Context parameter receives a
+// context that is canceled when the future is cancelled.
+//
+// The Go function runs without the GIL, so its parameters and result can't be PyObjects or
+// wrap them, and it must not call into Python other than through WithGIL.
+//
+//	lib.NewModule("net").Func("fetch", pylib.Async(func(ctx context.Context, url string) ([]byte, error) {
+//		return fetch(ctx, url)
+//	}), "url")
+func Async(fn any) any {
+	return asyncFunc{fn: fn}
+}
+
+// checkAsync verifies that an async function only exchanges Go values with Python.
+func (f *goFunc) checkAsync() error {
+	if f.hasSelf {
+		return fmt.Errorf("%s: an async function can't take a Self: %w", f.name, ErrTypeError)
+	}
+	types := f.params
+	if f.variadic != nil {
+		types = append(types[:len(types):len(types)], f.variadic)
+	}
+	if f.hasResult {
+		types = append(types[:len(types):len(types)], f.fn.Type().Out(0))
+	}
+	for _, t := range types {
+		if holdsPyObjects(t) {
+			return fmt.Errorf("%s: an async function runs without the GIL and can't exchange %s with Python: %w", f.name, t, ErrTypeError)
+		}
+	}
+	return nil
+}
+
+// holdsPyObjects reports whether values of type t are or contain Python objects.
+func holdsPyObjects(t reflect.Type) bool {
+	if t == pyObjectType || t.Implements(objectWrapperType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return holdsPyObjects(t.Elem())
+	case reflect.Map:
+		return holdsPyObjects(t.Key()) || holdsPyObjects(t.Elem())
+	}
+	return false
+}
+
+// asyncBridge owns the function that completes the futures of async functions, created on
+// first use
+type asyncBridge struct {
+	once     sync.Once
+	err      error
+	complete PyObject
+}
+
+// callAsync starts an async function in a goroutine and returns a new reference to the future
+// of its result.
+func (f *goFunc) callAsync(self PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error) {
+	p := f.lib
+	a := &p.asyncBridge
+	a.once.Do(func() {
+		a.complete, a.err = p.NewCallable(func(args ...PyObject) (PyObject, error) {
+			return 0, p.completeFuture(args[0], args[1], args[2])
+		})
+	})
+	if a.err != nil {
+		return 0, a.err
+	}
+
+	asyncio := p.ImportModule("asyncio")
+	if asyncio == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(asyncio)
+	loop := p.CallMethod(asyncio, "get_running_loop")
+	if loop == 0 {
+		return 0, p.FetchError()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	in, err := f.arguments(ctx, self, args, nargs, kwnames)
+	if err != nil {
+		cancel()
+		p.DecRef(loop)
+		return 0, err
+	}
+	future := p.CallMethod(loop, "create_future")
+	if future == 0 {
+		cancel()
+		p.DecRef(loop)
+		return 0, p.FetchError()
+	}
+
+	// the future is done when the goroutine completes it or when it is cancelled, and either
+	// way the context is no longer needed
+	onDone, err := p.NewCallable(func(args ...PyObject) (PyObject, error) {
+		cancel()
+		return 0, nil
+	})
+	if err == nil {
+		if r := p.CallMethod(future, "add_done_callback", onDone); r != 0 {
+			p.DecRef(r)
+		} else {
+			err = p.FetchError()
+		}
+		p.DecRef(onDone)
+	}
+	if err != nil {
+		cancel()
+		p.DecRef(future)
+		p.DecRef(loop)
+		return 0, err
+	}
+
+	// the goroutine owns the references to the loop and a reference to the future
+	p.IncRef(future)
+	go f.runAsync(loop, future, in)
+	return future, nil
+}
+
+// runAsync calls the Go function and hands its result to the loop, which completes the future.
+func (f *goFunc) runAsync(loop PyObject, future PyObject, in []reflect.Value) {
+	p := f.lib
+	var result reflect.Value
+	var err error
+	var panicked any
+	var stack []byte
+	func() {
+		defer func() {
+			if panicked = recover(); panicked != nil {
+				stack = debug.Stack()
+			}
+		}()
+		result, err = f.result(f.fn.Call(in))
+	}()
+	if panicked != nil && p.PanicHandler != nil {
+		func() {
+			defer func() { recover() }()
+			p.PanicHandler(panicked, stack)
+		}()
+	}
+
+	p.WithGIL(func() error {
+		defer p.DecRef(loop)
+		defer p.DecRef(future)
+
+		var value PyObject
+		failed := err != nil || panicked != nil
+		switch {
+		case panicked != nil:
+			p.raisePanic(panicked, stack)
+			value = p.fetchException()
+		case err != nil:
+			value = p.exceptionFromError(err, 0)
+		case !result.IsValid():
+			value = p.NewNone()
+		default:
+			if value, err = p.fromValue(result); err != nil {
+				failed = true
+				value = p.exceptionFromError(err, 0)
+			}
+		}
+		if value == 0 {
+			// the exception could not be created, the future gets the error of that
+			failed = true
+			value = p.fetchException()
+		}
+		defer p.DecRef(value)
+
+		var succeeded uintptr
+		if !failed {
+			succeeded = 1
+		}
+		ok := PyObject(p.Invoke("PyBool_FromLong", succeeded))
+		defer p.DecRef(ok)
+		r := p.CallMethod(loop, "call_soon_threadsafe", p.asyncBridge.complete, future, ok, value)
+		if r == 0 {
+			// the loop is closed, nobody awaits the future anymore
+			p.Invoke("PyErr_Clear")
+			return nil
+		}
+		p.DecRef(r)
+		return nil
+	})
+}
+
+// completeFuture sets the result of a future, or its exception if ok is false, unless it was
+// cancelled.  It runs on the loop thread.
+func (p *PythonLib) completeFuture(future PyObject, ok PyObject, value PyObject) error {
+	done := p.CallMethod(future, "done")
+	if done == 0 {
+		return p.FetchError()
+	}
+	isDone, err := p.AsBool(done)
+	p.DecRef(done)
+	if err != nil || isDone {
+		return err
+	}
+	method := "set_exception"
+	if isOK, _ := p.AsBool(ok); isOK {
+		method = "set_result"
+	}
+	r := p.CallMethod(future, method, value)
+	if r == 0 {
+		return p.FetchError()
+	}
+	p.DecRef(r)
+	return nil
+}
+
+// EventLoop is an asyncio event loop running in a Python thread of its own, on which Go code
+// runs coroutines and waits for their results.
+//
+//	loop, err := lib.NewEventLoop()
+//	defer loop.Close()
+//	result, err := loop.Await(ctx, coro)
+type EventLoop struct {
+	lib    *PythonLib
+	loop   PyObject
+	thread PyObject
+}
+
+// NewEventLoop creates an event loop and starts running it in a daemon thread.
+func (p *PythonLib) NewEventLoop() (*EventLoop, error) {
+	l := &EventLoop{lib: p}
+	err := p.WithGIL(func() error {
+		asyncio := p.ImportModule("asyncio")
+		if asyncio == 0 {
+			return p.FetchError()
+		}
+		defer p.DecRef(asyncio)
+		threading := p.ImportModule("threading")
+		if threading == 0 {
+			return p.FetchError()
+		}
+		defer p.DecRef(threading)
+
+		l.loop = p.CallMethod(asyncio, "new_event_loop")
+		if l.loop == 0 {
+			return p.FetchError()
+		}
+		runForever := p.GetAttrString(l.loop, "run_forever")
+		if runForever == 0 {
+			return l.release(p.FetchError())
+		}
+		defer p.DecRef(runForever)
+		name := p.NewUnicode("kindalib-asyncio")
+		defer p.DecRef(name)
+		none := p.NewNone()
+		defer p.DecRef(none)
+		// Thread(group, target, name)
+		l.thread = p.CallMethod(threading, "Thread", none, runForever, name)
+		if l.thread == 0 {
+			return l.release(p.FetchError())
+		}
+		daemon := PyObject(p.Invoke("PyBool_FromLong", 1))
+		defer p.DecRef(daemon)
+		if err := p.SetAttrString(l.thread, "daemon", daemon); err != nil {
+			return l.release(err)
+		}
+		r := p.CallMethod(l.thread, "start")
+		if r == 0 {
+			return l.release(p.FetchError())
+		}
+		p.DecRef(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// release drops the references of a loop that failed to start, returning err.
+func (l *EventLoop) release(err error) error {
+	p := l.lib
+	if l.thread != 0 {
+		p.DecRef(l.thread)
+	}
+	if r := p.CallMethod(l.loop, "close"); r != 0 {
+		p.DecRef(r)
+	}
+	p.DecRef(l.loop)
+	return err
+}
+
+// Loop returns a borrowed reference to the asyncio event loop.
+func (l *EventLoop) Loop() PyObject {
+	return l.loop
+}
+
+type awaitResult struct {
+	value PyObject
+	err   error
+}
+
+// Await runs the coroutine coro on the loop with asyncio.run_coroutine_threadsafe, waits for
+// it and returns a new reference to its result, or its exception as a *PyError.  When ctx is
+// canceled first, the task running the coroutine is cancelled, as Task.cancel() does, and
+// Await returns ctx.Err().
+//
+// Await can be called from any goroutine, and releases the GIL while it waits if the calling
+// thread holds it.  It must not be called from the loop's thread.
+func (l *EventLoop) Await(ctx context.Context, coro PyObject) (PyObject, error) {
+	p := l.lib
+	done := make(chan awaitResult, 1)
+	var future PyObject
+	err := p.WithGIL(func() error {
+		asyncio := p.ImportModule("asyncio")
+		if asyncio == 0 {
+			return p.FetchError()
+		}
+		defer p.DecRef(asyncio)
+		future = p.CallMethod(asyncio, "run_coroutine_threadsafe", coro, l.loop)
+		if future == 0 {
+			return p.FetchError()
+		}
+
+		// the callback runs on the loop thread, or on the thread that cancels the future
+		onDone, err := p.NewCallable(func(args ...PyObject) (PyObject, error) {
+			result := p.CallMethod(args[0], "result")
+			if result == 0 {
+				done <- awaitResult{err: p.FetchError()}
+			} else {
+				done <- awaitResult{value: result}
+			}
+			return 0, nil
+		})
+		if err == nil {
+			if r := p.CallMethod(future, "add_done_callback", onDone); r != 0 {
+				p.DecRef(r)
+			} else {
+				err = p.FetchError()
+			}
+			p.DecRef(onDone)
+		}
+		if err != nil {
+			p.DecRef(future)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer p.WithGIL(func() error {
+		p.DecRef(future)
+		return nil
+	})
+
+	var result awaitResult
+	var canceled bool
+	p.waitWithoutGIL(func() {
+		select {
+		case result = <-done:
+		case <-ctx.Done():
+			canceled = true
+		}
+	})
+	if !canceled {
+		return result.value, result.err
+	}
+
+	// cancelling the concurrent future cancels the task, unless it is already done
+	var cancelled bool
+	p.WithGIL(func() error {
+		r := p.CallMethod(future, "cancel")
+		if r == 0 {
+			return p.FetchError()
+		}
+		cancelled, _ = p.AsBool(r)
+		p.DecRef(r)
+		return nil
+	})
+	p.waitWithoutGIL(func() { result = <-done })
+	if !cancelled {
+		return result.value, result.err
+	}
+	if result.value != 0 {
+		p.WithGIL(func() error {
+			p.DecRef(result.value)
+			return nil
+		})
+	}
+	return 0, ctx.Err()
+}
+
+// Close stops the loop, waits for its thread to exit and closes the loop.  Tasks that are
+// still pending are destroyed.
+func (l *EventLoop) Close() error {
+	p := l.lib
+	return p.WithGIL(func() error {
+		stop := p.GetAttrString(l.loop, "stop")
+		if stop == 0 {
+			return p.FetchError()
+		}
+		r := p.CallMethod(l.loop, "call_soon_threadsafe", stop)
+		p.DecRef(stop)
+		if r == 0 {
+			return p.FetchError()
+		}
+		p.DecRef(r)
+		// join releases the GIL while it waits
+		if r = p.CallMethod(l.thread, "join"); r == 0 {
+			return p.FetchError()
+		}
+		p.DecRef(r)
+		if r = p.CallMethod(l.loop, "close"); r == 0 {
+			return p.FetchError()
+		}
+		p.DecRef(r)
+		p.DecRef(l.thread)
+		p.DecRef(l.loop)
+		return nil
+	})
+}
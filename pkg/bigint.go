@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"fmt"
+	"math/big"
+	"unsafe"
+)
+
+// PyLong_AsNativeBytes flags, from Include/cpython/longobject.h
+const (
+	Py_ASNATIVEBYTES_DEFAULTS        = -1
+	Py_ASNATIVEBYTES_BIG_ENDIAN      = 0
+	Py_ASNATIVEBYTES_LITTLE_ENDIAN   = 1
+	Py_ASNATIVEBYTES_NATIVE_ENDIAN   = 3
+	Py_ASNATIVEBYTES_UNSIGNED_BUFFER = 4
+	Py_ASNATIVEBYTES_REJECT_NEGATIVE = 8
+	Py_ASNATIVEBYTES_ALLOW_INDEX     = 16
+)
+
+// bigIntFunctions are the C API functions that convert ints to and from byte arrays.  The
+// underscore functions are private and skipped by the function table, and the native bytes
+// functions only exist since 3.13, so any of them may be nil.
+type bigIntFunctions struct {
+	// PyLong_AsNativeBytes(PyObject *v, void *buffer, Py_ssize_t n_bytes, int flags)
+	asNativeBytes func(uintptr, uintptr, int, int32) int
+	// PyLong_FromNativeBytes(const void *buffer, size_t n_bytes, int flags)
+	fromNativeBytes func(uintptr, uintptr, int32) uintptr
+	// _PyLong_AsByteArray(PyLongObject *v, unsigned char *bytes, size_t n, int little_endian, int is_signed)
+	asByteArray func(uintptr, uintptr, uintptr, int32, int32) int32
+	// _PyLong_FromByteArray(const unsigned char *bytes, size_t n, int little_endian, int is_signed)
+	fromByteArray func(uintptr, uintptr, int32, int32) uintptr
+}
+
+// bigIntAPI binds the byte array functions on first use.
+func (p *PythonLib) bigIntAPI() *bigIntFunctions {
+	p.bigIntOnce.Do(func() {
+		f := &bigIntFunctions{}
+		// functions that can't be found are left nil
+		p.RegisterFunc(&f.asNativeBytes, "PyLong_AsNativeBytes")
+		p.RegisterFunc(&f.fromNativeBytes, "PyLong_FromNativeBytes")
+		// 3.13 added a with_exceptions parameter to _PyLong_AsByteArray, but it also has
+		// PyLong_AsNativeBytes, which is preferred
+		if f.asNativeBytes == nil {
+			p.RegisterFunc(&f.asByteArray, "_PyLong_AsByteArray")
+		}
+		p.RegisterFunc(&f.fromByteArray, "_PyLong_FromByteArray")
+		p.bigIntFuncs = f
+	})
+	return p.bigIntFuncs
+}
+
+// NewBigInt converts x to a Python int and returns a new reference to it.
+func (p *PythonLib) NewBigInt(x *big.Int) (PyObject, error) {
+	if x.IsInt64() {
+		return PyObject(p.Invoke("PyLong_FromLongLong", uintptr(x.Int64()))), nil
+	}
+
+	f := p.bigIntAPI()
+	if f.fromNativeBytes == nil && f.fromByteArray == nil {
+		return p.bigIntFromHex(x)
+	}
+
+	b := twosComplement(x)
+	buf := p.Invoke("PyMem_Malloc", uintptr(len(b)))
+	if buf == 0 {
+		return 0, fmt.Errorf("could not allocate %d bytes: %w", len(b), ErrMemoryError)
+	}
+	defer p.Invoke("PyMem_Free", buf)
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(buf)), len(b)), b)
+
+	var retv PyObject
+	if f.fromNativeBytes != nil {
+		retv = PyObject(f.fromNativeBytes(buf, uintptr(len(b)), Py_ASNATIVEBYTES_LITTLE_ENDIAN))
+	} else {
+		retv = PyObject(f.fromByteArray(buf, uintptr(len(b)), 1, 1))
+	}
+	if retv == 0 {
+		return 0, p.FetchError()
+	}
+	return retv, nil
+}
+
+// bigIntFromHex converts x through its hex representation with PyLong_FromString.
+func (p *PythonLib) bigIntFromHex(x *big.Int) (PyObject, error) {
+	s := p.StrToPtr(x.Text(16))
+	defer p.FreeString(s)
+	retv := PyObject(p.Invoke("PyLong_FromString", s, 0, 16))
+	if retv == 0 {
+		return 0, p.FetchError()
+	}
+	return retv, nil
+}
+
+// AsBigInt converts a Python int, or any object implementing __index__, to a *big.Int.
+func (p *PythonLib) AsBigInt(obj PyObject) (*big.Int, error) {
+	index := PyObject(p.Invoke("PyNumber_Index", uintptr(obj)))
+	if index == 0 {
+		return nil, p.FetchError()
+	}
+	defer p.DecRef(index)
+
+	overflow := p.Invoke("PyMem_Calloc", 1, unsafe.Sizeof(int32(0)))
+	defer p.Invoke("PyMem_Free", overflow)
+	v := int64(p.Invoke("PyLong_AsLongLongAndOverflow", uintptr(index), overflow))
+	if *(*int32)(unsafe.Pointer(overflow)) == 0 {
+		if v == -1 && p.ErrorOccurred() {
+			return nil, p.FetchError()
+		}
+		return big.NewInt(v), nil
+	}
+
+	f := p.bigIntAPI()
+	if f.asNativeBytes == nil && f.asByteArray == nil {
+		return p.bigIntToHex(index)
+	}
+
+	// int.bit_length() excludes the sign, one more bit makes room for it in two's complement
+	bitlen := p.CallMethod(index, "bit_length")
+	if bitlen == 0 {
+		return nil, p.FetchError()
+	}
+	bits, err := p.AsInt64(bitlen)
+	p.DecRef(bitlen)
+	if err != nil {
+		return nil, err
+	}
+	n := int(bits)/8 + 1
+
+	buf := p.Invoke("PyMem_Malloc", uintptr(n))
+	if buf == 0 {
+		return nil, fmt.Errorf("could not allocate %d bytes: %w", n, ErrMemoryError)
+	}
+	defer p.Invoke("PyMem_Free", buf)
+	if f.asNativeBytes != nil {
+		if f.asNativeBytes(uintptr(index), buf, n, Py_ASNATIVEBYTES_LITTLE_ENDIAN) < 0 {
+			return nil, p.FetchError()
+		}
+	} else if f.asByteArray(uintptr(index), buf, uintptr(n), 1, 1) != 0 {
+		return nil, p.FetchError()
+	}
+	return fromTwosComplement(unsafe.Slice((*byte)(unsafe.Pointer(buf)), n)), nil
+}
+
+// bigIntToHex converts an int through its hex representation from PyNumber_ToBase.
+func (p *PythonLib) bigIntToHex(obj PyObject) (*big.Int, error) {
+	hex := PyObject(p.Invoke("PyNumber_ToBase", uintptr(obj), 16))
+	if hex == 0 {
+		return nil, p.FetchError()
+	}
+	defer p.DecRef(hex)
+	s, err := p.AsString(hex)
+	if err != nil {
+		return nil, err
+	}
+	// base 0 accepts the 0x and -0x prefixes
+	x, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("could not parse %q as an integer: %w", s, ErrValueError)
+	}
+	return x, nil
+}
+
+// twosComplement returns the little endian two's complement of x, with room for the sign bit.
+func twosComplement(x *big.Int) []byte {
+	n := x.BitLen()/8 + 1
+	v := x
+	if x.Sign() < 0 {
+		// 2^(8n) + x
+		v = new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+		v.Add(v, x)
+	}
+	b := v.FillBytes(make([]byte, n))
+	reverseBytes(b)
+	return b
+}
+
+// fromTwosComplement is the inverse of twosComplement.
+func fromTwosComplement(le []byte) *big.Int {
+	b := append([]byte(nil), le...)
+	reverseBytes(b)
+	x := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return x
+}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+// Buffer request flags for GetBuffer, from Include/pybuffer.h
+const (
+	PyBUF_SIMPLE         = 0
+	PyBUF_WRITABLE       = 0x0001
+	PyBUF_FORMAT         = 0x0004
+	PyBUF_ND             = 0x0008
+	PyBUF_STRIDES        = 0x0010 | PyBUF_ND
+	PyBUF_C_CONTIGUOUS   = 0x0020 | PyBUF_STRIDES
+	PyBUF_F_CONTIGUOUS   = 0x0040 | PyBUF_STRIDES
+	PyBUF_ANY_CONTIGUOUS = 0x0080 | PyBUF_STRIDES
+	PyBUF_INDIRECT       = 0x0100 | PyBUF_STRIDES
+
+	PyBUF_CONTIG     = PyBUF_ND | PyBUF_WRITABLE
+	PyBUF_CONTIG_RO  = PyBUF_ND
+	PyBUF_STRIDED    = PyBUF_STRIDES | PyBUF_WRITABLE
+	PyBUF_STRIDED_RO = PyBUF_STRIDES
+	PyBUF_RECORDS    = PyBUF_STRIDES | PyBUF_WRITABLE | PyBUF_FORMAT
+	PyBUF_RECORDS_RO = PyBUF_STRIDES | PyBUF_FORMAT
+	PyBUF_FULL       = PyBUF_INDIRECT | PyBUF_WRITABLE | PyBUF_FORMAT
+	PyBUF_FULL_RO    = PyBUF_INDIRECT | PyBUF_FORMAT
+
+	// memoryview access flags for PyMemoryView_FromMemory
+	PyBUF_READ  = 0x100
+	PyBUF_WRITE = 0x200
+)
+
+// pyBufferStruct mirrors the C Py_buffer struct.  Its layout is part of the stable ABI
+// since 3.11 and has not changed since the new buffer protocol was introduced.
+type pyBufferStruct struct {
+	buf        uintptr
+	obj        uintptr
+	len        int
+	itemsize   int
+	readonly   int32
+	ndim       int32
+	format     uintptr
+	shape      uintptr
+	strides    uintptr
+	suboffsets uintptr
+	internal   uintptr
+}
+
+// PyBuffer is a view of the memory of an object exporting the buffer protocol, such as bytes,
+// bytearray, memoryview, array.array or a numpy array.  The memory is not copied, so Data
+// and any slice obtained from the buffer are only valid until Release is called.
+type PyBuffer struct {
+	lib  *PythonLib
+	view *pyBufferStruct
+
+	// Data covers the exported memory, Len bytes starting at the buffer address, when it is
+	// contiguous.  It is nil for a strided view such as memoryview(b)[::-1], whose items are
+	// not the Len bytes following the buffer address; read those through Shape and Strides.
+	Data []byte
+	// Format is the struct module format of a single item, "B" if the exporter has none
+	Format   string
+	ItemSize int
+	ReadOnly bool
+	// Shape and Strides are in items and bytes respectively, one entry per dimension
+	Shape   []int
+	Strides []int
+}
+
+// BufferElement is the set of Go types a buffer can be viewed as.
+type BufferElement interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~int |
+		~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uint | ~uintptr |
+		~float32 | ~float64
+}
+
+// GetBuffer requests a buffer view of obj with PyObject_GetBuffer.  flags is a combination of
+// the PyBUF_* constants; PyBUF_FULL_RO accepts any exporter, add PyBUF_WRITABLE to require
+// writable memory.  The view must be released with Release.
+func (p *PythonLib) GetBuffer(obj PyObject, flags int) (*PyBuffer, error) {
+	// the Py_buffer is filled in by the exporter and handed back on release, so it lives in
+	// memory the Go garbage collector doesn't move
+	view := p.Invoke("PyMem_Calloc", 1, unsafe.Sizeof(pyBufferStruct{}))
+	if view == 0 {
+		return nil, fmt.Errorf("could not allocate Py_buffer: %w", ErrMemoryError)
+	}
+	if int32(p.Invoke("PyObject_GetBuffer", uintptr(obj), view, uintptr(flags))) != 0 {
+		p.Invoke("PyMem_Free", view)
+		return nil, p.FetchError()
+	}
+
+	b := &PyBuffer{
+		lib:  p,
+		view: (*pyBufferStruct)(unsafe.Pointer(view)),
+	}
+	v := b.view
+	switch {
+	case int32(p.Invoke("PyBuffer_IsContiguous", view, uintptr('A'))) == 0:
+		// the address of a strided view is its first item, which may be the last in memory
+	case v.len > 0:
+		b.Data = unsafe.Slice((*byte)(unsafe.Pointer(v.buf)), v.len)
+	default:
+		b.Data = []byte{}
+	}
+	b.ItemSize = v.itemsize
+	b.ReadOnly = v.readonly != 0
+	b.Format = "B"
+	if v.format != 0 {
+		b.Format = p.PtrToStr(v.format)
+	}
+
+	ndim := int(v.ndim)
+	switch {
+	case v.shape != 0:
+		b.Shape = append([]int(nil), unsafe.Slice((*int)(unsafe.Pointer(v.shape)), ndim)...)
+	case ndim > 0:
+		// without PyBUF_ND the exporter reports a flat buffer
+		b.Shape = []int{v.len / max(v.itemsize, 1)}
+	}
+	switch {
+	case v.strides != 0:
+		b.Strides = append([]int(nil), unsafe.Slice((*int)(unsafe.Pointer(v.strides)), ndim)...)
+	case len(b.Shape) > 0:
+		// no strides means C contiguous
+		b.Strides = make([]int, len(b.Shape))
+		stride := v.itemsize
+		for i := len(b.Shape) - 1; i >= 0; i-- {
+			b.Strides[i] = stride
+			stride *= b.Shape[i]
+		}
+	}
+	return b, nil
+}
+
+// WithBuffer requests a buffer view of obj, calls fn with it and releases it when fn returns.
+func (p *PythonLib) WithBuffer(obj PyObject, flags int, fn func(b *PyBuffer) error) error {
+	b, err := p.GetBuffer(obj, flags)
+	if err != nil {
+		return err
+	}
+	defer b.Release()
+	return fn(b)
+}
+
+// Release gives the buffer back to the exporter.  Data and any slices obtained from the
+// buffer must not be used afterwards.  Calling Release more than once is a no-op.
+func (b *PyBuffer) Release() {
+	if b.view == nil {
+		return
+	}
+	view := uintptr(unsafe.Pointer(b.view))
+	b.lib.Invoke("PyBuffer_Release", view)
+	b.lib.Invoke("PyMem_Free", view)
+	b.view = nil
+	b.Data = nil
+}
+
+// Len returns the size of the buffer in bytes, the size of its items for a strided view.
+func (b *PyBuffer) Len() int {
+	if b.view == nil {
+		return 0
+	}
+	return b.view.len
+}
+
+// NDim returns the number of dimensions of the buffer.
+func (b *PyBuffer) NDim() int {
+	return len(b.Shape)
+}
+
+// IsContiguous reports whether the buffer is C contiguous, which is required to view it as
+// a Go slice.
+func (b *PyBuffer) IsContiguous() bool {
+	if b.view == nil {
+		return false
+	}
+	return int32(b.lib.Invoke("PyBuffer_IsContiguous", uintptr(unsafe.Pointer(b.view)), uintptr('C'))) != 0
+}
+
+// BufferAs views a C contiguous buffer as a slice of T without copying.  The format of the
+// buffer must describe items of the same kind and size as T, e.g. "d" for float64 or "q" or
+// "l" for int64 on 64 bit Linux.  The slice is only valid until the buffer is released.
+func BufferAs[T BufferElement](b *PyBuffer) ([]T, error) {
+	if b.view == nil {
+		return nil, fmt.Errorf("buffer has been released")
+	}
+	var zero T
+	t := reflect.TypeOf(zero)
+	if err := checkBufferFormat(b.Format, b.ItemSize, t); err != nil {
+		return nil, err
+	}
+	if !b.IsContiguous() {
+		return nil, fmt.Errorf("buffer with strides %v is not C contiguous: %w", b.Strides, ErrBufferError)
+	}
+	if len(b.Data) == 0 {
+		return []T{}, nil
+	}
+	return unsafe.Slice((*T)(unsafe.Pointer(&b.Data[0])), len(b.Data)/int(t.Size())), nil
+}
+
+// checkBufferFormat verifies a struct module format string describes native items that can be
+// read as t.
+func checkBufferFormat(format string, itemsize int, t reflect.Type) error {
+	code := format
+	if len(code) > 1 {
+		switch code[0] {
+		case '@', '=':
+			code = code[1:]
+		case '<':
+			if !isLittleEndian() {
+				return fmt.Errorf("buffer format %q is not native byte order: %w", format, ErrBufferError)
+			}
+			code = code[1:]
+		case '>', '!':
+			if isLittleEndian() {
+				return fmt.Errorf("buffer format %q is not native byte order: %w", format, ErrBufferError)
+			}
+			code = code[1:]
+		}
+	}
+	if len(code) != 1 {
+		return fmt.Errorf("buffer format %q is not a single native item: %w", format, ErrBufferError)
+	}
+
+	var kind reflect.Kind
+	switch {
+	case strings.ContainsRune("bhilqn", rune(code[0])):
+		kind = reflect.Int
+	case strings.ContainsRune("BHILQNPc", rune(code[0])):
+		kind = reflect.Uint
+	case strings.ContainsRune("fd", rune(code[0])):
+		kind = reflect.Float64
+	default:
+		return fmt.Errorf("unsupported buffer format %q: %w", format, ErrBufferError)
+	}
+
+	var tkind reflect.Kind
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		tkind = reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		tkind = reflect.Uint
+	case reflect.Float32, reflect.Float64:
+		tkind = reflect.Float64
+	}
+	if tkind != kind || int(t.Size()) != itemsize {
+		return fmt.Errorf("buffer format %q with item size %d can't be viewed as %s: %w", format, itemsize, t, ErrBufferError)
+	}
+	return nil
+}
+
+func isLittleEndian() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"fmt"
+	"runtime/debug"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// PyCFunction is a Go implementation of a METH_VARARGS, METH_O or METH_NOARGS method.  The
+// returned object is a new reference handed to Python.  Returning a non-nil error raises it
+// with SetError, and returning 0 with a nil error returns None.
+type PyCFunction func(self PyObject, args PyObject) (PyObject, error)
+
+// PyCFunctionWithKeywords is a Go implementation of a METH_VARARGS|METH_KEYWORDS method.
+// kwargs is 0 when the method was called without keyword arguments.
+type PyCFunctionWithKeywords func(self PyObject, args PyObject, kwargs PyObject) (PyObject, error)
+
+// PyCFunctionFastWithKeywords is a Go implementation of a METH_FASTCALL|METH_KEYWORDS method.
+// args views the argument vector CPython passes, without copying it into a tuple: the nargs
+// positional arguments followed by the value of each keyword argument named in the kwnames
+// tuple.  kwnames is 0 when the method was called without keyword arguments.  The arguments
+// are borrowed references, and args must not be kept after the call returns.
+type PyCFunctionFastWithKeywords func(self PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error)
+
+// NewCallback wraps fn in a native callback suitable for SetMethodDef.  A panic in fn is
+// recovered at the callback boundary and raised in Python as SystemError, or as
+// PanicException when it is set, so it never unwinds through the interpreter's C frames.
+func (p *PythonLib) NewCallback(fn PyCFunction) uintptr {
+	cb := func(self uintptr, args uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+		return p.callbackResult(fn(PyObject(self), PyObject(args)))
+	}
+	return purego.NewCallback(cb)
+}
+
+// NewCallbackWithKeywords wraps fn in a native callback suitable for SetMethodDef with
+// METH_VARARGS|METH_KEYWORDS.  Panics are recovered the same way as NewCallback.
+func (p *PythonLib) NewCallbackWithKeywords(fn PyCFunctionWithKeywords) uintptr {
+	cb := func(self uintptr, args uintptr, kwargs uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+		return p.callbackResult(fn(PyObject(self), PyObject(args), PyObject(kwargs)))
+	}
+	return purego.NewCallback(cb)
+}
+
+// NewCallbackFastWithKeywords wraps fn in a native callback suitable for SetMethodDef with
+// METH_FASTCALL|METH_KEYWORDS, which spares CPython building an argument tuple and keyword
+// dict for every call.  Panics are recovered the same way as NewCallback.
+func (p *PythonLib) NewCallbackFastWithKeywords(fn PyCFunctionFastWithKeywords) uintptr {
+	cb := func(self uintptr, args uintptr, nargs int, kwnames uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+		argv := p.vectorView(args, nargs, kwnames)
+		return p.callbackResult(fn(PyObject(self), argv, nargs, PyObject(kwnames)))
+	}
+	return purego.NewCallback(cb)
+}
+
+// vectorView returns the METH_FASTCALL argument vector at args as a slice, without copying it.
+func (p *PythonLib) vectorView(args uintptr, nargs int, kwnames uintptr) []PyObject {
+	n := nargs
+	if kwnames != 0 {
+		n += int(p.Invoke("PyTuple_Size", kwnames))
+	}
+	if n == 0 {
+		return nil
+	}
+	return unsafe.Slice((*PyObject)(unsafe.Pointer(args)), n)
+}
+
+// callbackResult converts the Go return values of a callback to the PyObject* CPython expects.
+func (p *PythonLib) callbackResult(result PyObject, err error) uintptr {
+	if err != nil {
+		p.DecRef(result)
+		return p.RaiseError(err)
+	}
+	if result == 0 {
+		if p.ErrorOccurred() {
+			return 0
+		}
+		p.IncRef(PyObject(p.PyNone))
+		return p.PyNone
+	}
+	return uintptr(result)
+}
+
+// callbackFailure is the -1 that the slots returning a C int or a Py_ssize_t return on failure
+const callbackFailure = ^uintptr(0)
+
+// recoverCallback must be deferred by every Go function called from C.  It converts a panic
+// into a Python exception and makes the callback return failure: 0, the NULL of the slots
+// returning an object, or callbackFailure for the slots returning a status or a size.
+func (p *PythonLib) recoverCallback(retv *uintptr, failure uintptr) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	*retv = failure
+
+	if p.PanicHandler != nil {
+		// a panicking handler must not escape into C either
+		func() {
+			defer func() { recover() }()
+			p.PanicHandler(r, stack)
+		}()
+	}
+
+	p.raisePanic(r, stack)
+}
+
+// raisePanic raises PanicException, or SystemError if it is not set, for a recovered panic
+// value.  The exception carries the panic value and Go stack as the go_panic and go_stack
+// attributes, and as a note on versions that support them.
+func (p *PythonLib) raisePanic(value any, stack []byte) {
+	excType := p.PanicException
+	if excType == 0 {
+		excType = p.GetPyExc("SystemError")
+	}
+
+	// a panic may leave a half raised exception behind, it is replaced by the panic
+	p.Invoke("PyErr_Clear")
+
+	msg := p.NewUnicode(fmt.Sprintf("go callback panicked: %v", value))
+	defer p.DecRef(msg)
+	exc := p.CallObject(excType, msg)
+	if exc == 0 {
+		return
+	}
+	defer p.DecRef(exc)
+
+	goPanic := p.NewUnicode(fmt.Sprint(value))
+	p.SetAttrString(exc, "go_panic", goPanic)
+	p.DecRef(goPanic)
+
+	goStack := p.NewUnicode(string(stack))
+	p.SetAttrString(exc, "go_stack", goStack)
+
+	// BaseException.add_note was added in 3.11
+	if note := p.CallMethod(exc, "add_note", goStack); note != 0 {
+		p.DecRef(note)
+	}
+	p.DecRef(goStack)
+	p.Invoke("PyErr_Clear")
+
+	t := p.Invoke("PyObject_Type", uintptr(exc))
+	p.Invoke("PyErr_SetObject", t, uintptr(exc))
+	p.Invoke("Py_DecRef", t)
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ebitengine/purego"
+)
+
+// goCapsules owns the destructor of the capsules created by NewCapsule and the Go values
+// they hold
+type goCapsules struct {
+	once       sync.Once
+	destructor uintptr
+	values     handleTable
+}
+
+// NewCapsule returns a new reference to a PyCapsule named name that holds v, so Python code
+// can carry a Go value it can't use and hand it back to Go later.  The capsule holds a handle
+// rather than a pointer, and the handle is released when Python drops the capsule.  By
+// convention, name is the dotted path of the attribute the capsule is stored in, such as
+// "mymodule.conn".
+func (p *PythonLib) NewCapsule(name string, v any) (PyObject, error) {
+	c := &p.capsules
+	c.once.Do(func() { c.destructor = purego.NewCallback(p.releaseCapsule) })
+
+	// the capsule keeps a pointer to its name, which the destructor frees
+	var cname uintptr
+	if name != "" {
+		cname = p.StrToPtr(name)
+	}
+	handle := c.values.add(v)
+	capsule := PyObject(p.Invoke("PyCapsule_New", handle, cname, c.destructor))
+	if capsule == 0 {
+		c.values.remove(handle)
+		p.FreeString(cname)
+		return 0, p.FetchError()
+	}
+	return capsule, nil
+}
+
+// CapsuleValue returns the Go value of a capsule created by NewCapsule.  It fails if obj is
+// not such a capsule or is not named name, so a capsule meant for another purpose is never
+// mistaken for the expected one.
+func (p *PythonLib) CapsuleValue(obj PyObject, name string) (any, error) {
+	if uintptr(p.typeOf(obj)) != p.PyData["PyCapsule_Type"] {
+		return nil, fmt.Errorf("expected a capsule, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	c := &p.capsules
+	if c.destructor == 0 || p.Invoke("PyCapsule_GetDestructor", uintptr(obj)) != c.destructor {
+		return nil, fmt.Errorf("capsule does not hold a Go value: %w", ErrTypeError)
+	}
+	cname := p.Invoke("PyCapsule_GetName", uintptr(obj))
+	var capsuleName string
+	if cname != 0 {
+		capsuleName = p.PtrToStr(cname)
+	}
+	if capsuleName != name {
+		return nil, fmt.Errorf("expected a capsule named %q, got %q: %w", name, capsuleName, ErrValueError)
+	}
+	handle := p.Invoke("PyCapsule_GetPointer", uintptr(obj), cname)
+	if handle == 0 {
+		return nil, p.FetchError()
+	}
+	v, ok := c.values.get(handle)
+	if !ok {
+		return nil, fmt.Errorf("capsule %q was released: %w", name, ErrValueError)
+	}
+	return v, nil
+}
+
+// CapsuleAs returns the Go value of a capsule created by NewCapsule as a T, checking the
+// capsule's name and the value's type.
+func CapsuleAs[T any](lib IPythonLib, obj PyObject, name string) (T, error) {
+	var zero T
+	v, err := lib.CapsuleValue(obj, name)
+	if err != nil {
+		return zero, err
+	}
+	retv, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("capsule %q holds %T, not %T: %w", name, v, zero, ErrTypeError)
+	}
+	return retv, nil
+}
+
+// releaseCapsule is the destructor of the capsules created by NewCapsule.
+func (p *PythonLib) releaseCapsule(capsule uintptr) {
+	var ignored uintptr
+	defer p.recoverCallback(&ignored, 0)
+
+	cname := p.Invoke("PyCapsule_GetName", capsule)
+	if handle := p.Invoke("PyCapsule_GetPointer", capsule, cname); handle != 0 {
+		p.capsules.values.remove(handle)
+	}
+	if cname != 0 {
+		p.FreeString(cname)
+	}
+}
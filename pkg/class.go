@@ -0,0 +1,455 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ClassBuilder declares a Python class whose instances are backed by Go values.  The
+// constructor passed to NewClass implements __init__: it takes the arguments of the class
+// call, converted like the arguments of a ModuleBuilder Func, and returns the pointer the
+// instance holds.  Methods, properties and __repr__ are Go functions taking that pointer as
+// their first parameter, so method expressions such as (*Conn).Close can be used directly.
+// The instance drops the Go value when it is deallocated.  Python code can subclass the class.
+//
+// The class supports the Python protocols whose interfaces, such as Lenner, Getter or Adder,
+// its Go type implements.  Functions taking the pointer type accept instances of the class,
+// and Go values of the pointer type returned to Python become new instances.
+//
+//	conn := lib.NewClass("Conn", func(addr string) (*Conn, error) { return Dial(addr) }, "addr").
+//		Method("send", (*Conn).Send, "data").
+//		Property("addr", func(c *Conn) string { return c.addr }, nil).
+//		Repr(func(c *Conn) string { return "<Conn " + c.addr + ">" })
+//	lib.NewModule("net").Class(conn).Register()
+//
+// Like ModuleBuilder, the builder methods remember the first error, which is returned when
+// the class is created.
+type ClassBuilder struct {
+	lib     *PythonLib
+	name    string
+	doc     string
+	goType  reflect.Type
+	init    *goFunc
+	methods []moduleFunc
+	props   []classProperty
+	repr    *goFunc
+	special []protocolMethod
+	err     error
+
+	// the slots and tables of the type, built on first use and kept for the lifetime of the
+	// PythonLib, as Python keeps pointers to the tables
+	slots      []PyType_Slot
+	methodDefs PyMethodDefArray
+	getsetDefs PyGetSetDefArray
+
+	// strong references to the type objects created from the class, the latest last
+	types []PyObject
+}
+
+type classProperty struct {
+	name string
+	get  *goFunc
+	set  *goFunc
+}
+
+// NewClass starts the declaration of a class named name.  constructor is a Go function that
+// returns a pointer, optionally with an error; its parameters are the arguments of the class
+// call, and argNames lets Python pass them by keyword as with ModuleBuilder Func.
+func (p *PythonLib) NewClass(name string, constructor any, argNames ...string) *ClassBuilder {
+	c := &ClassBuilder{lib: p, name: name}
+	f, err := p.newGoFunc(name, constructor, argNames)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	t := f.fn.Type()
+	if !f.hasResult || t.Out(0).Kind() != reflect.Pointer {
+		c.err = fmt.Errorf("%s: the constructor must return a pointer, got %s: %w", name, t, ErrTypeError)
+		return c
+	}
+	c.init = f
+	c.goType = t.Out(0)
+	return c
+}
+
+// Doc sets the class docstring.
+func (c *ClassBuilder) Doc(doc string) *ClassBuilder {
+	c.doc = doc
+	return c
+}
+
+// Method adds a method.  fn takes the instance's Go value as its first parameter, followed by
+// the method arguments, which are converted as for ModuleBuilder Func.
+func (c *ClassBuilder) Method(name string, fn any, argNames ...string) *ClassBuilder {
+	return c.MethodDoc(name, "", fn, argNames...)
+}
+
+// MethodDoc adds a method with a docstring, see Method.
+func (c *ClassBuilder) MethodDoc(name string, doc string, fn any, argNames ...string) *ClassBuilder {
+	if c.err != nil {
+		return c
+	}
+	f, err := c.lib.newGoMethod(name, fn, argNames, c.goType, c.value)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	c.methods = append(c.methods, moduleFunc{fn: f, doc: doc})
+	return c
+}
+
+// Property adds an attribute computed by get, a func(*T) V that may also return an error.  set
+// is a func(*T, V) that may return an error, or nil for a read-only attribute.
+func (c *ClassBuilder) Property(name string, get any, set any) *ClassBuilder {
+	if c.err != nil {
+		return c
+	}
+	prop := classProperty{name: name}
+	var err error
+	prop.get, err = c.lib.newGoMethod(name, get, nil, c.goType, c.value)
+	if err == nil && len(prop.get.params) != 0 {
+		err = fmt.Errorf("%s: a getter takes only the receiver: %w", name, ErrTypeError)
+	}
+	if err == nil && set != nil {
+		prop.set, err = c.lib.newGoMethod(name, set, nil, c.goType, c.value)
+		if err == nil && len(prop.set.params) != 1 {
+			err = fmt.Errorf("%s: a setter takes the receiver and the value: %w", name, ErrTypeError)
+		}
+	}
+	if err != nil {
+		c.err = err
+		return c
+	}
+	c.props = append(c.props, prop)
+	return c
+}
+
+// Repr sets __repr__ to fn, a func(*T) string.
+func (c *ClassBuilder) Repr(fn any) *ClassBuilder {
+	if c.err != nil {
+		return c
+	}
+	f, err := c.lib.newGoMethod("__repr__", fn, nil, c.goType, c.value)
+	if err == nil && (len(f.params) != 0 || !f.hasResult || f.fn.Type().Out(0).Kind() != reflect.String) {
+		err = fmt.Errorf("__repr__: expected a func(%s) string, got %s: %w", c.goType, f.fn.Type(), ErrTypeError)
+	}
+	if err != nil {
+		c.err = err
+		return c
+	}
+	c.repr = f
+	return c
+}
+
+// Create creates the class as a new type and returns a new reference to it.  module is the
+// module the class belongs to, or 0.  A ModuleBuilder Class creates it for every module
+// object.
+func (c *ClassBuilder) Create(module PyObject) (PyObject, error) {
+	p := c.lib
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.slots == nil {
+		if err := c.build(); err != nil {
+			return 0, err
+		}
+	}
+
+	qualname := c.name
+	if module != 0 {
+		name := p.Invoke("PyModule_GetName", uintptr(module))
+		if name == 0 {
+			return 0, p.FetchError()
+		}
+		qualname = p.PtrToStr(name) + "." + c.name
+	}
+	// the instances hold a handle to their Go value after the object header
+	basicsize := p.PyObjectHeadSize() + int(unsafe.Sizeof(uintptr(0)))
+	t, err := p.NewTypeFromModuleAndSpec(module, qualname, basicsize, 0, Py_TPFLAGS_DEFAULT|Py_TPFLAGS_BASETYPE, c.slots, 0)
+	if err != nil {
+		return 0, err
+	}
+	p.IncRef(t)
+	c.types = append(c.types, t)
+	if p.classTypes == nil {
+		p.classTypes = make(map[PyObject]*ClassBuilder)
+	}
+	p.classTypes[t] = c
+	return t, nil
+}
+
+// build creates the slots and tables of the type.
+func (c *ClassBuilder) build() error {
+	p := c.lib
+	genericNew, err := OpenSymbol(p.DLL, "PyType_GenericNew")
+	if err != nil {
+		return err
+	}
+	slots := []PyType_Slot{
+		{Slot: Py_tp_new, PFunc: genericNew},
+		{Slot: Py_tp_init, PFunc: p.classCallback("tp_init", p.initInstance)},
+		{Slot: Py_tp_dealloc, PFunc: p.classCallback("tp_dealloc", p.deallocInstance)},
+	}
+	// PyType_FromSpec copies the docstring, but the slots are used again for every module
+	// object, so it is never freed.  It starts with the signature of the constructor.
+	doc := c.init.signatureDoc(c.name, "", c.doc)
+	slots = append(slots, PyType_Slot{Slot: Py_tp_doc, PFunc: p.StrToPtr(doc)})
+	if c.repr != nil {
+		slots = append(slots, PyType_Slot{Slot: Py_tp_repr, PFunc: p.classCallback("tp_repr", p.reprInstance)})
+	}
+
+	slots = append(slots, c.protocolSlots()...)
+
+	c.special = c.protocolMethods()
+	if len(c.methods)+len(c.special) != 0 {
+		c.methodDefs = p.NewPyMethodDefArray(len(c.methods) + len(c.special))
+		for i, m := range c.methods {
+			c.methodDefs.SetMethodDef(i, m.fn.name, p.methodTrampoline(i, m.fn.flags()), m.fn.flags())
+			c.methodDefs.SetMethodDoc(i, m.fn.signatureDoc(m.fn.name, "$self", m.doc))
+		}
+		for i, m := range c.special {
+			j := len(c.methods) + i
+			c.methodDefs.SetMethodDef(j, m.name, p.methodTrampoline(j, m.flags), m.flags)
+		}
+		slots = append(slots, PyType_Slot{Slot: Py_tp_methods, PFunc: c.methodDefs.GetBuffer()})
+	}
+
+	if len(c.props) != 0 {
+		c.getsetDefs = p.NewPyGetSetDefArray(len(c.props))
+		get := p.classCallback("getter", p.getProperty)
+		for i, prop := range c.props {
+			var set uintptr
+			if prop.set != nil {
+				set = p.classCallback("setter", p.setProperty)
+			}
+			// the closure is the index of the property
+			c.getsetDefs.SetGetSetDef(i, prop.name, get, set, "")
+			c.getsetDefs.SetClosure(i, uintptr(i))
+		}
+		slots = append(slots, PyType_Slot{Slot: Py_tp_getset, PFunc: c.getsetDefs.GetBuffer()})
+	}
+
+	c.slots = slots
+	p.classDefs = append(p.classDefs, c)
+	return nil
+}
+
+// classCallbacks are the slot and attribute callbacks of the types of every class, by name.
+// They find the class from the type of the instance they are called with, so defining
+// classes doesn't use up native callbacks.
+type classCallbacks struct {
+	mu        sync.Mutex
+	callbacks map[string]uintptr
+}
+
+// classCallback returns the callback named name, creating it from fn on first use.
+func (p *PythonLib) classCallback(name string, fn any) uintptr {
+	cc := &p.classCallbacks
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cb, ok := cc.callbacks[name]; ok {
+		return cb
+	}
+	if cc.callbacks == nil {
+		cc.callbacks = make(map[string]uintptr)
+	}
+	cb := purego.NewCallback(fn)
+	cc.callbacks[name] = cb
+	return cb
+}
+
+// instanceClass returns the class of an instance of a type created from a ClassBuilder, or
+// of a Python subclass of one, or nil.
+func (p *PythonLib) instanceClass(self PyObject) *ClassBuilder {
+	t := p.typeOf(self)
+	for t != 0 {
+		if c, ok := p.classTypes[t]; ok {
+			return c
+		}
+		// the types of classes and their subclasses are heap types
+		if p.Invoke("PyType_GetFlags", uintptr(t))&Py_TPFLAGS_HEAPTYPE == 0 {
+			return nil
+		}
+		t = PyObject(p.GetTypeSlot(t, Py_tp_base))
+	}
+	return nil
+}
+
+// instanceValue returns the class and the Go value of self, the instance a slot or attribute
+// callback is called with.
+func (p *PythonLib) instanceValue(self PyObject) (*ClassBuilder, reflect.Value, error) {
+	c := p.instanceClass(self)
+	if c == nil {
+		return nil, reflect.Value{}, p.notInstance(self)
+	}
+	v, err := c.value(self)
+	return c, v, err
+}
+
+// notInstance is the error of a class callback called with an object whose type was not
+// created from a ClassBuilder.
+func (p *PythonLib) notInstance(obj PyObject) error {
+	return fmt.Errorf("%s is not the type of a Go class: %w", p.ObjectToRepr(p.typeOf(obj)), ErrSystemError)
+}
+
+// reprInstance is tp_repr.
+func (p *PythonLib) reprInstance(self uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, 0)
+	c := p.instanceClass(PyObject(self))
+	if c == nil || c.repr == nil {
+		return p.RaiseError(p.notInstance(PyObject(self)))
+	}
+	return p.callbackResult(c.repr.call(PyObject(self), nil, 0, 0))
+}
+
+// getProperty is the getter of every property, whose index is the closure.
+func (p *PythonLib) getProperty(self uintptr, closure uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, 0)
+	c := p.instanceClass(PyObject(self))
+	if c == nil {
+		return p.RaiseError(p.notInstance(PyObject(self)))
+	}
+	return p.callbackResult(c.props[closure].get.call(PyObject(self), nil, 0, 0))
+}
+
+// setProperty is the setter of every writable property, whose index is the closure.
+func (p *PythonLib) setProperty(self uintptr, value uintptr, closure uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, callbackFailure)
+	c := p.instanceClass(PyObject(self))
+	if c == nil {
+		p.SetError(p.notInstance(PyObject(self)))
+		return callbackFailure
+	}
+	set := c.props[closure].set
+	if value == 0 {
+		p.SetError(fmt.Errorf("cannot delete attribute '%s': %w", set.name, ErrTypeError))
+		return callbackFailure
+	}
+	if _, err := set.call(PyObject(self), []PyObject{PyObject(value)}, 1, 0); err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	return 0
+}
+
+// handleOf returns the location of the handle of an instance's Go value.
+func (c *ClassBuilder) handleOf(self PyObject) *uintptr {
+	return c.lib.valueHandle(self)
+}
+
+// valueHandle returns the location of the handle of the Go value of an instance of any class.
+func (p *PythonLib) valueHandle(self PyObject) *uintptr {
+	return (*uintptr)(unsafe.Pointer(uintptr(self) + uintptr(p.PyObjectHeadSize())))
+}
+
+// value finds the Go value of an instance, the receiver of its methods.
+func (c *ClassBuilder) value(self PyObject) (reflect.Value, error) {
+	v, ok := c.lib.classValues.get(*c.handleOf(self))
+	if !ok {
+		// a subclass __init__ that doesn't call the base __init__ leaves no value
+		return reflect.Value{}, fmt.Errorf("%s object is not initialized: %w", c.name, ErrValueError)
+	}
+	return reflect.ValueOf(v), nil
+}
+
+// isInstance reports whether obj is an instance of one of the types created from the class.
+func (c *ClassBuilder) isInstance(obj PyObject) bool {
+	t := c.lib.typeOf(obj)
+	for _, ct := range c.types {
+		if t == ct || c.lib.isInstance(obj, uintptr(ct)) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueOf returns the Go value of obj if it is an initialized instance of the class.
+func (c *ClassBuilder) valueOf(obj PyObject) (any, bool) {
+	if !c.isInstance(obj) {
+		return nil, false
+	}
+	return c.lib.classValues.get(*c.handleOf(obj))
+}
+
+// newInstance returns a new instance of the latest type created from the class, holding v
+// without calling the constructor.
+func (c *ClassBuilder) newInstance(v any) (PyObject, error) {
+	p := c.lib
+	obj := PyObject(p.Invoke("PyType_GenericAlloc", uintptr(c.types[len(c.types)-1]), 0))
+	if obj == 0 {
+		return 0, p.FetchError()
+	}
+	*c.handleOf(obj) = p.classValues.add(v)
+	return obj, nil
+}
+
+// classOf returns the latest class whose Go values have type t and that has been created,
+// or nil.
+func (p *PythonLib) classOf(t reflect.Type) *ClassBuilder {
+	for i := len(p.classDefs) - 1; i >= 0; i-- {
+		if c := p.classDefs[i]; c.goType == t && len(c.types) != 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// classValueOf returns the Go value of an instance of any class defined with NewClass.
+func (p *PythonLib) classValueOf(obj PyObject) (any, bool) {
+	for _, c := range p.classDefs {
+		if v, ok := c.valueOf(obj); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// initInstance is tp_init: it calls the constructor and stores the Go value it returns,
+// replacing the value of an instance initialized before.
+func (p *PythonLib) initInstance(self uintptr, args uintptr, kwargs uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, callbackFailure)
+
+	c := p.instanceClass(PyObject(self))
+	if c == nil {
+		p.SetError(p.notInstance(PyObject(self)))
+		return callbackFailure
+	}
+	argv, nargs, kwnames, err := p.vectorArgs(PyObject(args), PyObject(kwargs))
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	defer p.DecRef(kwnames)
+	v, err := c.init.invoke(PyObject(self), argv, nargs, kwnames)
+	if err == nil && v.IsNil() {
+		err = fmt.Errorf("%s() constructor returned nil: %w", c.name, ErrValueError)
+	}
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+
+	handle := p.valueHandle(PyObject(self))
+	if *handle != 0 {
+		p.classValues.remove(*handle)
+	}
+	*handle = p.classValues.add(v.Interface())
+	return 0
+}
+
+// deallocInstance is tp_dealloc: it drops the Go value and frees the instance.
+func (p *PythonLib) deallocInstance(self uintptr) {
+	var ignored uintptr
+	defer p.recoverCallback(&ignored, 0)
+
+	handle := p.valueHandle(PyObject(self))
+	if *handle != 0 {
+		p.classValues.remove(*handle)
+		*handle = 0
+	}
+	p.FreeHeapObject(PyObject(self))
+}
@@ -0,0 +1,673 @@
+package pkg
+
+import (
+	"fmt"
+	"iter"
+)
+
+// pyContainer is the part shared by the container wrappers.  A wrapper owns one strong
+// reference to its object, which is dropped by Release.
+type pyContainer struct {
+	lib *PythonLib
+	obj PyObject
+}
+
+// objectWrapper is implemented by the container wrappers so FromGo can pass their objects
+// through unchanged.
+type objectWrapper interface {
+	Object() PyObject
+}
+
+// Object returns the wrapped object as a borrowed reference, valid until Release.
+func (c *pyContainer) Object() PyObject {
+	return c.obj
+}
+
+// Release drops the wrapper's reference to the object.  Calling Release more than once is a
+// no-op.
+func (c *pyContainer) Release() {
+	if c.obj != 0 {
+		c.lib.DecRef(c.obj)
+		c.obj = 0
+	}
+}
+
+// Len returns the number of items in the container.
+func (c *pyContainer) Len() int {
+	n := int(c.lib.Invoke("PyObject_Size", uintptr(c.obj)))
+	if n < 0 {
+		c.lib.Invoke("PyErr_Clear")
+		return 0
+	}
+	return n
+}
+
+// Contains reports whether the container holds value, the Python "value in container".
+// Dict containment tests the keys.
+func (c *pyContainer) Contains(value any) (bool, error) {
+	v, err := c.lib.FromGo(value)
+	if err != nil {
+		return false, err
+	}
+	defer c.lib.DecRef(v)
+	ret := int32(c.lib.Invoke("PySequence_Contains", uintptr(c.obj), uintptr(v)))
+	if ret < 0 {
+		return false, c.lib.FetchError()
+	}
+	return ret == 1, nil
+}
+
+// String returns the repr of the container.
+func (c *pyContainer) String() string {
+	return c.lib.ObjectToRepr(c.obj)
+}
+
+// wrapObject checks the type of obj and takes a new reference to it for a wrapper.
+func (p *PythonLib) wrapObject(obj PyObject, kind string, ok bool) (pyContainer, error) {
+	if obj == 0 {
+		return pyContainer{}, fmt.Errorf("cannot wrap NULL as a %s: %w", kind, ErrTypeError)
+	}
+	if !ok {
+		return pyContainer{}, fmt.Errorf("expected a %s, got %s: %w", kind, p.GetTypeName(obj), ErrTypeError)
+	}
+	p.IncRef(obj)
+	return pyContainer{lib: p, obj: obj}, nil
+}
+
+// itemRef yields a borrowed item while holding a reference to it, so the loop body can
+// change the container.
+func itemRef[K any](p *PythonLib, key K, item PyObject, yield func(K, PyObject) bool) bool {
+	p.IncRef(item)
+	defer p.DecRef(item)
+	return yield(key, item)
+}
+
+// Dict wraps a Python dict.
+//
+//	d, err := lib.NewDict()
+//	...
+//	defer d.Release()
+//	d.Set("answer", 42)
+//	for key, value := range d.All() {
+//		...
+//	}
+type Dict struct {
+	pyContainer
+}
+
+// NewDict creates an empty dict.
+func (p *PythonLib) NewDict() (*Dict, error) {
+	obj := PyObject(p.Invoke("PyDict_New"))
+	if obj == 0 {
+		return nil, p.FetchError()
+	}
+	return &Dict{pyContainer{lib: p, obj: obj}}, nil
+}
+
+// AsDict wraps an existing dict or dict subclass.  The reference to obj is borrowed; the
+// wrapper takes its own.
+func (p *PythonLib) AsDict(obj PyObject) (*Dict, error) {
+	c, err := p.wrapObject(obj, "dict", obj != 0 && p.hasTypeFlag(obj, Py_TPFLAGS_DICT_SUBCLASS))
+	if err != nil {
+		return nil, err
+	}
+	return &Dict{c}, nil
+}
+
+// Get returns a new reference to the value stored under key, and false if there is none.
+func (d *Dict) Get(key any) (PyObject, bool, error) {
+	p := d.lib
+	k, err := p.FromGo(key)
+	if err != nil {
+		return 0, false, err
+	}
+	defer p.DecRef(k)
+	value := PyObject(p.Invoke("PyDict_GetItemWithError", uintptr(d.obj), uintptr(k)))
+	if value == 0 {
+		if p.ErrorOccurred() {
+			return 0, false, p.FetchError()
+		}
+		return 0, false, nil
+	}
+	p.IncRef(value)
+	return value, true, nil
+}
+
+// GetInto converts the value stored under key into target with ToGo, and reports false
+// without touching target if there is none.
+func (d *Dict) GetInto(key any, target any) (bool, error) {
+	value, ok, err := d.Get(key)
+	if !ok {
+		return false, err
+	}
+	defer d.lib.DecRef(value)
+	return true, d.lib.ToGo(value, target)
+}
+
+// Set converts key and value with FromGo and stores them.
+func (d *Dict) Set(key any, value any) error {
+	p := d.lib
+	k, err := p.FromGo(key)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(k)
+	v, err := p.FromGo(value)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(v)
+	if int32(p.Invoke("PyDict_SetItem", uintptr(d.obj), uintptr(k), uintptr(v))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// Delete removes key from the dict.  Like the delete builtin, deleting a missing key is a
+// no-op.
+func (d *Dict) Delete(key any) error {
+	p := d.lib
+	k, err := p.FromGo(key)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(k)
+	if int32(p.Invoke("PyDict_DelItem", uintptr(d.obj), uintptr(k))) != 0 {
+		err := p.FetchError()
+		if isPyError(err, ErrKeyError) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Clear removes every item from the dict.
+func (d *Dict) Clear() {
+	d.lib.Invoke("PyDict_Clear", uintptr(d.obj))
+}
+
+// All yields the key and value pairs of the dict as borrowed references that are released
+// when the loop body returns.
+func (d *Dict) All() iter.Seq2[PyObject, PyObject] {
+	it := &PyIter{lib: d.lib, obj: d.obj}
+	return func(yield func(PyObject, PyObject) bool) {
+		// dict subclasses are walked in place too, Items would go through their items()
+		it.dictItems(yield)
+	}
+}
+
+// Keys yields the keys of the dict as borrowed references.
+func (d *Dict) Keys() iter.Seq[PyObject] {
+	return func(yield func(PyObject) bool) {
+		for key := range d.All() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// Values yields the values of the dict as borrowed references.
+func (d *Dict) Values() iter.Seq[PyObject] {
+	return func(yield func(PyObject) bool) {
+		for _, value := range d.All() {
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
+
+// List wraps a Python list.
+type List struct {
+	pyContainer
+}
+
+// NewList creates a list of values converted with FromGo.
+func (p *PythonLib) NewList(values ...any) (*List, error) {
+	b := p.NewListBuilder(len(values))
+	for _, v := range values {
+		b.Append(v)
+	}
+	return b.Build()
+}
+
+// AsList wraps an existing list or list subclass.  The reference to obj is borrowed; the
+// wrapper takes its own.
+func (p *PythonLib) AsList(obj PyObject) (*List, error) {
+	c, err := p.wrapObject(obj, "list", obj != 0 && p.hasTypeFlag(obj, Py_TPFLAGS_LIST_SUBCLASS))
+	if err != nil {
+		return nil, err
+	}
+	return &List{c}, nil
+}
+
+// Get returns a new reference to the item at index i.  An index out of range is an IndexError.
+func (l *List) Get(i int) (PyObject, error) {
+	item := PyObject(l.lib.Invoke("PyList_GetItem", uintptr(l.obj), uintptr(i)))
+	if item == 0 {
+		return 0, l.lib.FetchError()
+	}
+	l.lib.IncRef(item)
+	return item, nil
+}
+
+// GetInto converts the item at index i into target with ToGo.
+func (l *List) GetInto(i int, target any) error {
+	item, err := l.Get(i)
+	if err != nil {
+		return err
+	}
+	defer l.lib.DecRef(item)
+	return l.lib.ToGo(item, target)
+}
+
+// Set replaces the item at index i with value converted by FromGo.
+func (l *List) Set(i int, value any) error {
+	p := l.lib
+	v, err := p.FromGo(value)
+	if err != nil {
+		return err
+	}
+	// PyList_SetItem steals the reference to v, even when it fails
+	if int32(p.Invoke("PyList_SetItem", uintptr(l.obj), uintptr(i), uintptr(v))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// Append adds values to the end of the list.
+func (l *List) Append(values ...any) error {
+	p := l.lib
+	for _, value := range values {
+		v, err := p.FromGo(value)
+		if err != nil {
+			return err
+		}
+		ret := int32(p.Invoke("PyList_Append", uintptr(l.obj), uintptr(v)))
+		p.DecRef(v)
+		if ret != 0 {
+			return p.FetchError()
+		}
+	}
+	return nil
+}
+
+// Insert inserts value before index i.
+func (l *List) Insert(i int, value any) error {
+	p := l.lib
+	v, err := p.FromGo(value)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(v)
+	if int32(p.Invoke("PyList_Insert", uintptr(l.obj), uintptr(i), uintptr(v))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// Delete removes the item at index i.
+func (l *List) Delete(i int) error {
+	if int32(l.lib.Invoke("PySequence_DelItem", uintptr(l.obj), uintptr(i))) != 0 {
+		return l.lib.FetchError()
+	}
+	return nil
+}
+
+// All yields the index and item of each element of the list, the item as a borrowed reference
+// that is released when the loop body returns.  The length is checked on every step, so the
+// loop body may change the list.
+func (l *List) All() iter.Seq2[int, PyObject] {
+	p := l.lib
+	return func(yield func(int, PyObject) bool) {
+		for i := 0; i < int(p.Invoke("PyList_Size", uintptr(l.obj))); i++ {
+			item := PyObject(p.Invoke("PyList_GetItem", uintptr(l.obj), uintptr(i)))
+			if !itemRef(p, i, item, yield) {
+				return
+			}
+		}
+	}
+}
+
+// Tuple wraps a Python tuple.
+type Tuple struct {
+	pyContainer
+}
+
+// NewTuple creates a tuple of values converted with FromGo.
+func (p *PythonLib) NewTuple(values ...any) (*Tuple, error) {
+	b := p.NewTupleBuilder(len(values))
+	for _, v := range values {
+		b.Append(v)
+	}
+	return b.Build()
+}
+
+// AsTuple wraps an existing tuple or tuple subclass, such as a named tuple.  The reference
+// to obj is borrowed; the wrapper takes its own.
+func (p *PythonLib) AsTuple(obj PyObject) (*Tuple, error) {
+	c, err := p.wrapObject(obj, "tuple", obj != 0 && p.hasTypeFlag(obj, Py_TPFLAGS_TUPLE_SUBCLASS))
+	if err != nil {
+		return nil, err
+	}
+	return &Tuple{c}, nil
+}
+
+// Get returns a new reference to the item at index i.  An index out of range is an IndexError.
+func (t *Tuple) Get(i int) (PyObject, error) {
+	item := PyObject(t.lib.Invoke("PyTuple_GetItem", uintptr(t.obj), uintptr(i)))
+	if item == 0 {
+		return 0, t.lib.FetchError()
+	}
+	t.lib.IncRef(item)
+	return item, nil
+}
+
+// GetInto converts the item at index i into target with ToGo.
+func (t *Tuple) GetInto(i int, target any) error {
+	item, err := t.Get(i)
+	if err != nil {
+		return err
+	}
+	defer t.lib.DecRef(item)
+	return t.lib.ToGo(item, target)
+}
+
+// All yields the index and item of each element of the tuple, the item as a borrowed
+// reference.
+func (t *Tuple) All() iter.Seq2[int, PyObject] {
+	p := t.lib
+	return func(yield func(int, PyObject) bool) {
+		n := int(p.Invoke("PyTuple_Size", uintptr(t.obj)))
+		for i := 0; i < n; i++ {
+			item := PyObject(p.Invoke("PyTuple_GetItem", uintptr(t.obj), uintptr(i)))
+			if !yield(i, item) {
+				return
+			}
+		}
+	}
+}
+
+// Set wraps a Python set or frozenset.
+type Set struct {
+	pyContainer
+	err error
+}
+
+// NewSet creates a set of values converted with FromGo.
+func (p *PythonLib) NewSet(values ...any) (*Set, error) {
+	b := p.NewSetBuilder()
+	for _, v := range values {
+		b.Add(v)
+	}
+	return b.Build()
+}
+
+// AsSet wraps an existing set, frozenset or subclass of either.  The reference to obj is
+// borrowed; the wrapper takes its own.
+func (p *PythonLib) AsSet(obj PyObject) (*Set, error) {
+	ok := obj != 0 && (p.isSubtype(obj, "PySet_Type") || p.isSubtype(obj, "PyFrozenSet_Type"))
+	c, err := p.wrapObject(obj, "set", ok)
+	if err != nil {
+		return nil, err
+	}
+	return &Set{pyContainer: c}, nil
+}
+
+// Add converts value with FromGo and adds it to the set.
+func (s *Set) Add(value any) error {
+	p := s.lib
+	v, err := p.FromGo(value)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(v)
+	if int32(p.Invoke("PySet_Add", uintptr(s.obj), uintptr(v))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// Delete removes value from the set.  Deleting a missing value is a no-op.
+func (s *Set) Delete(value any) error {
+	p := s.lib
+	v, err := p.FromGo(value)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(v)
+	if int32(p.Invoke("PySet_Discard", uintptr(s.obj), uintptr(v))) < 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// Clear removes every item from the set.
+func (s *Set) Clear() error {
+	if int32(s.lib.Invoke("PySet_Clear", uintptr(s.obj))) != 0 {
+		return s.lib.FetchError()
+	}
+	return nil
+}
+
+// All yields the items of the set as borrowed references.  Changing the set from the loop
+// body ends the iteration with a RuntimeError reported by Err.
+func (s *Set) All() iter.Seq[PyObject] {
+	return func(yield func(PyObject) bool) {
+		it := s.lib.Iterate(s.obj)
+		defer func() { s.err = it.Err() }()
+		for item := range it.All() {
+			if !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that ended the last iteration with All, if any.
+func (s *Set) Err() error {
+	return s.err
+}
+
+// ListBuilder builds a list item by item.  The list is allocated up front for the expected
+// number of items and the items are stored without any intermediate Go values.  The first
+// error stops the build and is returned by Build.
+//
+//	b := lib.NewListBuilder(len(rows))
+//	for _, row := range rows {
+//		b.Append(row.Name)
+//	}
+//	list, err := b.Build()
+type ListBuilder struct {
+	lib  *PythonLib
+	list PyObject
+	n    int
+	err  error
+}
+
+// NewListBuilder starts a list with room for capacity items.  Appending more items than
+// that grows the list.
+func (p *PythonLib) NewListBuilder(capacity int) *ListBuilder {
+	b := &ListBuilder{lib: p}
+	b.list = PyObject(p.Invoke("PyList_New", uintptr(capacity)))
+	if b.list == 0 {
+		b.err = p.FetchError()
+	}
+	return b
+}
+
+// Append converts value with FromGo and adds it to the list.
+func (b *ListBuilder) Append(value any) *ListBuilder {
+	if b.err != nil {
+		return b
+	}
+	p := b.lib
+	v, err := p.FromGo(value)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	if b.n < int(p.Invoke("PyList_Size", uintptr(b.list))) {
+		// fill the preallocated slot, PyList_SetItem steals the reference to v
+		p.Invoke("PyList_SetItem", uintptr(b.list), uintptr(b.n), uintptr(v))
+	} else {
+		ret := int32(p.Invoke("PyList_Append", uintptr(b.list), uintptr(v)))
+		p.DecRef(v)
+		if ret != 0 {
+			b.err = p.FetchError()
+			return b
+		}
+	}
+	b.n++
+	return b
+}
+
+// Build returns the finished list, trimmed to the items appended.  The builder must not be
+// used afterwards.
+func (b *ListBuilder) Build() (*List, error) {
+	p := b.lib
+	list := b.list
+	b.list = 0
+	if b.err != nil {
+		if list != 0 {
+			p.DecRef(list)
+		}
+		return nil, b.err
+	}
+	if size := int(p.Invoke("PyList_Size", uintptr(list))); b.n < size {
+		// drop the unused NULL slots
+		if int32(p.Invoke("PyList_SetSlice", uintptr(list), uintptr(b.n), uintptr(size), 0)) != 0 {
+			p.DecRef(list)
+			return nil, p.FetchError()
+		}
+	}
+	return &List{pyContainer{lib: p, obj: list}}, nil
+}
+
+// TupleBuilder builds a tuple item by item.  The tuple is only created by Build, once its
+// size is known, since a tuple must not be seen before it is filled.
+type TupleBuilder struct {
+	lib   *PythonLib
+	items []PyObject
+	err   error
+}
+
+// NewTupleBuilder starts a tuple expected to hold capacity items.
+func (p *PythonLib) NewTupleBuilder(capacity int) *TupleBuilder {
+	return &TupleBuilder{lib: p, items: make([]PyObject, 0, capacity)}
+}
+
+// Append converts value with FromGo and adds it to the tuple.
+func (b *TupleBuilder) Append(value any) *TupleBuilder {
+	if b.err != nil {
+		return b
+	}
+	v, err := b.lib.FromGo(value)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.items = append(b.items, v)
+	return b
+}
+
+// Build returns the finished tuple.  The builder must not be used afterwards.
+func (b *TupleBuilder) Build() (*Tuple, error) {
+	p := b.lib
+	items := b.items
+	b.items = nil
+	if b.err != nil {
+		for _, item := range items {
+			p.DecRef(item)
+		}
+		return nil, b.err
+	}
+	tuple := PyObject(p.Invoke("PyTuple_New", uintptr(len(items))))
+	if tuple == 0 {
+		for _, item := range items {
+			p.DecRef(item)
+		}
+		return nil, p.FetchError()
+	}
+	for i, item := range items {
+		// PyTuple_SetItem steals the reference to item
+		p.Invoke("PyTuple_SetItem", uintptr(tuple), uintptr(i), uintptr(item))
+	}
+	return &Tuple{pyContainer{lib: p, obj: tuple}}, nil
+}
+
+// DictBuilder builds a dict entry by entry.  The first error stops the build and is returned
+// by Build.
+type DictBuilder struct {
+	dict *Dict
+	err  error
+}
+
+// NewDictBuilder starts an empty dict.
+func (p *PythonLib) NewDictBuilder() *DictBuilder {
+	d, err := p.NewDict()
+	return &DictBuilder{dict: d, err: err}
+}
+
+// Set converts key and value with FromGo and stores them in the dict.
+func (b *DictBuilder) Set(key any, value any) *DictBuilder {
+	if b.err == nil {
+		b.err = b.dict.Set(key, value)
+	}
+	return b
+}
+
+// Build returns the finished dict.  The builder must not be used afterwards.
+func (b *DictBuilder) Build() (*Dict, error) {
+	d := b.dict
+	b.dict = nil
+	if b.err != nil {
+		if d != nil {
+			d.Release()
+		}
+		return nil, b.err
+	}
+	return d, nil
+}
+
+// SetBuilder builds a set item by item.  The first error stops the build and is returned by
+// Build.
+type SetBuilder struct {
+	set *Set
+	err error
+}
+
+// NewSetBuilder starts an empty set.
+func (p *PythonLib) NewSetBuilder() *SetBuilder {
+	b := &SetBuilder{}
+	obj := PyObject(p.Invoke("PySet_New", 0))
+	if obj == 0 {
+		b.err = p.FetchError()
+		return b
+	}
+	b.set = &Set{pyContainer: pyContainer{lib: p, obj: obj}}
+	return b
+}
+
+// Add converts value with FromGo and adds it to the set.
+func (b *SetBuilder) Add(value any) *SetBuilder {
+	if b.err == nil {
+		b.err = b.set.Add(value)
+	}
+	return b
+}
+
+// Build returns the finished set.  The builder must not be used afterwards.
+func (b *SetBuilder) Build() (*Set, error) {
+	s := b.set
+	b.set = nil
+	if b.err != nil {
+		if s != nil {
+			s.Release()
+		}
+		return nil, b.err
+	}
+	return s, nil
+}
@@ -0,0 +1,674 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// floatFunctions are the C API functions that pass doubles, which Invoke can't call
+type floatFunctions struct {
+	asDouble   func(uintptr) float64
+	fromDouble func(float64) uintptr
+}
+
+var pyObjectType = reflect.TypeOf(PyObject(0))
+
+var objectWrapperType = reflect.TypeOf((*objectWrapper)(nil)).Elem()
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	civilDateType     = reflect.TypeOf(CivilDate{})
+	civilTimeType     = reflect.TypeOf(CivilTime{})
+	civilDateTimeType = reflect.TypeOf(CivilDateTime{})
+)
+
+// floatAPI binds the floating point functions on first use.
+func (p *PythonLib) floatAPI() *floatFunctions {
+	p.floatOnce.Do(func() {
+		f := &floatFunctions{}
+		p.RegisterFunc(&f.asDouble, "PyFloat_AsDouble")
+		p.RegisterFunc(&f.fromDouble, "PyFloat_FromDouble")
+		p.floatFuncs = f
+	})
+	return p.floatFuncs
+}
+
+// typeOf returns a borrowed reference to the type of obj.
+func (p *PythonLib) typeOf(obj PyObject) PyObject {
+	// reading ob_type straight from the object header saves two calls into the library on
+	// a path taken for nearly every conversion
+	if off := p.CTags.PyStructs.PyObject.GetMemberOffset("ob_type"); off >= 0 {
+		return *(*PyObject)(unsafe.Pointer(uintptr(obj) + uintptr(off)))
+	}
+	t := PyObject(p.Invoke("PyObject_Type", uintptr(obj)))
+	// a type is kept alive by its instances, so the reference can be dropped right away
+	p.DecRef(t)
+	return t
+}
+
+// hasTypeFlag reports whether the type of obj has the given Py_TPFLAGS_* flag, which is how the
+// Py*_Check macros test for the built-in types and their subclasses.
+func (p *PythonLib) hasTypeFlag(obj PyObject, flag uintptr) bool {
+	return p.Invoke("PyType_GetFlags", uintptr(p.typeOf(obj)))&flag != 0
+}
+
+// isSubtype reports whether obj is an instance of the named static type from PyData, e.g.
+// "PyFloat_Type", or of a subclass of it.
+func (p *PythonLib) isSubtype(obj PyObject, typeName string) bool {
+	t, ok := p.PyData[typeName]
+	if !ok {
+		return false
+	}
+	return int32(p.Invoke("PyType_IsSubtype", uintptr(p.typeOf(obj)), t)) != 0
+}
+
+// IsNone reports whether obj is the None singleton.
+func (p *PythonLib) IsNone(obj PyObject) bool {
+	return uintptr(obj) == p.PyNone
+}
+
+// NewNone returns a new reference to None.
+func (p *PythonLib) NewNone() PyObject {
+	p.IncRef(PyObject(p.PyNone))
+	return PyObject(p.PyNone)
+}
+
+// NewFloat creates a Python float and returns a new reference to it.
+func (p *PythonLib) NewFloat(v float64) PyObject {
+	return PyObject(p.floatAPI().fromDouble(v))
+}
+
+// NewBytes creates a Python bytes object holding a copy of b and returns a new reference to it.
+func (p *PythonLib) NewBytes(b []byte) PyObject {
+	if len(b) == 0 {
+		return PyObject(p.Invoke("PyBytes_FromStringAndSize", 0, 0))
+	}
+	buf := p.Invoke("PyMem_Malloc", uintptr(len(b)))
+	defer p.Invoke("PyMem_Free", buf)
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(buf)), len(b)), b)
+	return PyObject(p.Invoke("PyBytes_FromStringAndSize", buf, uintptr(len(b))))
+}
+
+// AsInt64 converts a Python int to an int64.  Values that don't fit return an error wrapping
+// ErrOverflowError instead of silently truncating.
+func (p *PythonLib) AsInt64(obj PyObject) (int64, error) {
+	if !p.hasTypeFlag(obj, Py_TPFLAGS_LONG_SUBCLASS) {
+		return 0, fmt.Errorf("expected int, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	v := int64(p.Invoke("PyLong_AsLongLong", uintptr(obj)))
+	if v == -1 && p.ErrorOccurred() {
+		return 0, p.FetchError()
+	}
+	return v, nil
+}
+
+// AsUint64 converts a non-negative Python int to a uint64.
+func (p *PythonLib) AsUint64(obj PyObject) (uint64, error) {
+	if !p.hasTypeFlag(obj, Py_TPFLAGS_LONG_SUBCLASS) {
+		return 0, fmt.Errorf("expected int, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	v := uint64(p.Invoke("PyLong_AsUnsignedLongLong", uintptr(obj)))
+	if v == math.MaxUint64 && p.ErrorOccurred() {
+		return 0, p.FetchError()
+	}
+	return v, nil
+}
+
+// AsFloat64 converts a Python float, or any object implementing __float__ or __index__, to a float64.
+func (p *PythonLib) AsFloat64(obj PyObject) (float64, error) {
+	v := p.floatAPI().asDouble(uintptr(obj))
+	if v == -1 && p.ErrorOccurred() {
+		return 0, p.FetchError()
+	}
+	return v, nil
+}
+
+// AsString converts a Python str to a Go string.
+func (p *PythonLib) AsString(obj PyObject) (string, error) {
+	if !p.hasTypeFlag(obj, Py_TPFLAGS_UNICODE_SUBCLASS) {
+		return "", fmt.Errorf("expected str, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+
+	// PyUnicode_AsUTF8AndSize reports the length so embedded NULs survive
+	size := p.Invoke("PyMem_Malloc", unsafe.Sizeof(uintptr(0)))
+	defer p.Invoke("PyMem_Free", size)
+	s := p.Invoke("PyUnicode_AsUTF8AndSize", uintptr(obj), size)
+	if s == 0 {
+		return "", p.FetchError()
+	}
+	n := *(*int)(unsafe.Pointer(size))
+	return string(unsafe.Slice((*byte)(unsafe.Pointer(s)), n)), nil
+}
+
+// AsBool converts a Python bool to a Go bool.
+func (p *PythonLib) AsBool(obj PyObject) (bool, error) {
+	if uintptr(p.typeOf(obj)) != p.PyData["PyBool_Type"] {
+		return false, fmt.Errorf("expected bool, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	return int32(p.Invoke("PyObject_IsTrue", uintptr(obj))) == 1, nil
+}
+
+// AsBytes copies the contents of a Python bytes or bytearray object.
+func (p *PythonLib) AsBytes(obj PyObject) ([]byte, error) {
+	var data, size uintptr
+	switch {
+	case p.hasTypeFlag(obj, Py_TPFLAGS_BYTES_SUBCLASS):
+		data = p.Invoke("PyBytes_AsString", uintptr(obj))
+		size = p.Invoke("PyBytes_Size", uintptr(obj))
+	case p.isSubtype(obj, "PyByteArray_Type"):
+		data = p.Invoke("PyByteArray_AsString", uintptr(obj))
+		size = p.Invoke("PyByteArray_Size", uintptr(obj))
+	default:
+		return nil, fmt.Errorf("expected bytes, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	retv := make([]byte, int(size))
+	if size > 0 {
+		copy(retv, unsafe.Slice((*byte)(unsafe.Pointer(data)), int(size)))
+	}
+	return retv, nil
+}
+
+// FromGo converts a Go value to a Python object and returns a new reference to it.
+//
+//	nil, nil pointers, maps and slices  None
+//	PyObject                            the object itself, with its reference count incremented
+//	*Dict, *List, *Tuple, *Set          the wrapped object, with its reference count incremented
+//	big.Int, *big.Int                   int
+//	time.Time                           datetime.datetime, see NewDateTime
+//	time.Duration                       datetime.timedelta
+//	CivilDate, CivilTime, CivilDateTime datetime.date, datetime.time and naive datetime.datetime
+//	bool                                bool
+//	signed and unsigned integers        int
+//	float32, float64                    float
+//	complex64, complex128               complex
+//	string                              str
+//	[]byte                              bytes
+//	slices and arrays                   list
+//	maps                                dict
+//	structs                             dict of the exported fields, named by their `py` tag if present
+func (p *PythonLib) FromGo(v any) (PyObject, error) {
+	if v == nil {
+		return p.NewNone(), nil
+	}
+	return p.fromValue(reflect.ValueOf(v))
+}
+
+func (p *PythonLib) fromValue(v reflect.Value) (PyObject, error) {
+	if v.Type() == pyObjectType {
+		obj := PyObject(v.Uint())
+		if obj == 0 {
+			return p.NewNone(), nil
+		}
+		p.IncRef(obj)
+		return obj, nil
+	}
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		// the Go value of a class defined with NewClass becomes an instance of it
+		if c := p.classOf(v.Type()); c != nil {
+			return c.newInstance(v.Interface())
+		}
+	}
+	if v.Type().Implements(objectWrapperType) && !(v.Kind() == reflect.Pointer && v.IsNil()) {
+		// a Dict, List, Tuple or Set stands for the object it wraps
+		return p.fromValue(reflect.ValueOf(v.Interface().(objectWrapper).Object()))
+	}
+	if v.Type() == bigIntType {
+		x := v.Interface().(big.Int)
+		return p.NewBigInt(&x)
+	}
+	switch v.Type() {
+	case timeType:
+		return p.NewDateTime(v.Interface().(time.Time))
+	case durationType:
+		return p.NewTimedelta(time.Duration(v.Int()))
+	case civilDateType:
+		return p.NewCivilDate(v.Interface().(CivilDate))
+	case civilTimeType:
+		return p.NewCivilTime(v.Interface().(CivilTime))
+	case civilDateTimeType:
+		return p.NewCivilDateTime(v.Interface().(CivilDateTime))
+	}
+
+	var retv PyObject
+	switch v.Kind() {
+	case reflect.Bool:
+		var b uintptr
+		if v.Bool() {
+			b = 1
+		}
+		retv = PyObject(p.Invoke("PyBool_FromLong", b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		retv = PyObject(p.Invoke("PyLong_FromLongLong", uintptr(v.Int())))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		retv = PyObject(p.Invoke("PyLong_FromUnsignedLongLong", uintptr(v.Uint())))
+	case reflect.Float32, reflect.Float64:
+		retv = p.NewFloat(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		return p.NewComplex(v.Complex())
+	case reflect.String:
+		retv = p.NewUnicode(v.String())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return p.NewNone(), nil
+		}
+		return p.fromValue(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return p.NewNone(), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			retv = p.NewBytes(v.Bytes())
+			break
+		}
+		return p.fromList(v)
+	case reflect.Array:
+		return p.fromList(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return p.NewNone(), nil
+		}
+		return p.fromMap(v)
+	case reflect.Struct:
+		return p.fromStruct(v)
+	default:
+		return 0, fmt.Errorf("cannot convert Go %s to a Python object: %w", v.Type(), ErrTypeError)
+	}
+
+	if retv == 0 {
+		return 0, p.FetchError()
+	}
+	return retv, nil
+}
+
+func (p *PythonLib) fromList(v reflect.Value) (PyObject, error) {
+	list := p.Invoke("PyList_New", uintptr(v.Len()))
+	if list == 0 {
+		return 0, p.FetchError()
+	}
+	for i := 0; i < v.Len(); i++ {
+		item, err := p.fromValue(v.Index(i))
+		if err != nil {
+			p.Invoke("Py_DecRef", list)
+			return 0, err
+		}
+		// PyList_SetItem steals the reference to item
+		p.Invoke("PyList_SetItem", list, uintptr(i), uintptr(item))
+	}
+	return PyObject(list), nil
+}
+
+func (p *PythonLib) fromMap(v reflect.Value) (PyObject, error) {
+	dict := PyObject(p.Invoke("PyDict_New"))
+	if dict == 0 {
+		return 0, p.FetchError()
+	}
+	iter := v.MapRange()
+	for iter.Next() {
+		if err := p.setDictValue(dict, iter.Key(), iter.Value()); err != nil {
+			p.DecRef(dict)
+			return 0, err
+		}
+	}
+	return dict, nil
+}
+
+func (p *PythonLib) fromStruct(v reflect.Value) (PyObject, error) {
+	dict := PyObject(p.Invoke("PyDict_New"))
+	if dict == 0 {
+		return 0, p.FetchError()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := pyFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		if err := p.setDictValue(dict, reflect.ValueOf(name), v.Field(i)); err != nil {
+			p.DecRef(dict)
+			return 0, err
+		}
+	}
+	return dict, nil
+}
+
+// setDictValue converts key and value and stores them in dict.
+func (p *PythonLib) setDictValue(dict PyObject, key reflect.Value, value reflect.Value) error {
+	k, err := p.fromValue(key)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(k)
+	val, err := p.fromValue(value)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(val)
+	if int32(p.Invoke("PyDict_SetItem", uintptr(dict), uintptr(k), uintptr(val))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// pyFieldName returns the Python name of an exported struct field, honoring a `py:"name"` tag.
+// A tag of "-" skips the field.
+func pyFieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	tag := f.Tag.Get("py")
+	if tag == "-" {
+		return "", false
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name, true
+	}
+	return f.Name, true
+}
+
+// ToGo converts a Python object into the Go value pointed to by target.  It is the inverse of
+// FromGo: ints convert to any integer type that can hold them or to big.Int, floats and ints
+// to floating point types, complex and real numbers to complex types, str to string, bytes and
+// bytearray to []byte, any iterable to a slice, dicts to maps and structs, datetime objects to
+// time.Time, time.Duration and the Civil types, and None to nil pointers, maps and slices.  A
+// PyObject target receives a new reference.  Converting into an interface{} picks the natural
+// Go type for the object.  Conversion errors wrap ErrTypeError or ErrOverflowError.
+func (p *PythonLib) ToGo(obj PyObject, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("ToGo target must be a non-nil pointer, got %T", target)
+	}
+	return p.toValue(obj, v.Elem())
+}
+
+// Convert converts a Python object to a Go value of type T with ToGo.
+func Convert[T any](lib IPythonLib, obj PyObject) (T, error) {
+	var retv T
+	err := lib.ToGo(obj, &retv)
+	return retv, err
+}
+
+func (p *PythonLib) toValue(obj PyObject, v reflect.Value) error {
+	if v.Type() == pyObjectType {
+		p.IncRef(obj)
+		v.SetUint(uint64(obj))
+		return nil
+	}
+
+	if p.IsNone(obj) {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.SetZero()
+			return nil
+		}
+		return fmt.Errorf("cannot convert None to %s: %w", v.Type(), ErrTypeError)
+	}
+
+	if v.Kind() == reflect.Pointer {
+		if c := p.classOf(v.Type()); c != nil {
+			if value, ok := c.valueOf(obj); ok {
+				v.Set(reflect.ValueOf(value))
+				return nil
+			}
+		}
+	}
+
+	if v.Type() == bigIntType {
+		x, err := p.AsBigInt(obj)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(x).Elem())
+		return nil
+	}
+
+	var natural any
+	var err error
+	switch v.Type() {
+	case timeType:
+		natural, err = p.AsTime(obj)
+	case durationType:
+		natural, err = p.AsDuration(obj)
+	case civilDateType:
+		natural, err = p.AsCivilDate(obj)
+	case civilTimeType:
+		natural, err = p.AsCivilTime(obj)
+	case civilDateTimeType:
+		natural, err = p.AsCivilDateTime(obj)
+	}
+	if err != nil {
+		return err
+	}
+	if natural != nil {
+		v.Set(reflect.ValueOf(natural))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := p.AsBool(obj)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := p.AsInt64(obj)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("%d does not fit in %s: %w", i, v.Type(), ErrOverflowError)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := p.AsUint64(obj)
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("%d does not fit in %s: %w", u, v.Type(), ErrOverflowError)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := p.AsFloat64(obj)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := p.AsComplex128(obj)
+		if err != nil {
+			return err
+		}
+		v.SetComplex(c)
+	case reflect.String:
+		s, err := p.AsString(obj)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		elem := reflect.New(v.Type().Elem())
+		if err := p.toValue(obj, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := p.AsBytes(obj)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		return p.toSlice(obj, v)
+	case reflect.Array:
+		return p.toArray(obj, v)
+	case reflect.Map:
+		return p.toMap(obj, v)
+	case reflect.Struct:
+		return p.toStruct(obj, v)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("cannot convert %s to %s: %w", p.GetTypeName(obj), v.Type(), ErrTypeError)
+		}
+		natural, err := p.toInterface(obj)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+	default:
+		return fmt.Errorf("cannot convert to Go %s: %w", v.Type(), ErrTypeError)
+	}
+	return nil
+}
+
+// toInterface converts obj into its natural Go representation: nil, bool, int64, float64,
+// complex128, string, []byte, []any or map[string]any.  Dicts with non-str keys become
+// map[any]any, ints too large for an int64 *big.Int, and datetime objects time.Time,
+// time.Duration, CivilDate or CivilTime.  Instances of classes defined with NewClass become
+// their Go value.
+func (p *PythonLib) toInterface(obj PyObject) (any, error) {
+	switch {
+	case p.IsNone(obj):
+		return nil, nil
+	case uintptr(p.typeOf(obj)) == p.PyData["PyBool_Type"]:
+		return p.AsBool(obj)
+	case p.hasTypeFlag(obj, Py_TPFLAGS_LONG_SUBCLASS):
+		i, err := p.AsInt64(obj)
+		if isPyError(err, ErrOverflowError) {
+			return p.AsBigInt(obj)
+		}
+		return i, err
+	case p.isSubtype(obj, "PyFloat_Type"):
+		return p.AsFloat64(obj)
+	case p.isSubtype(obj, "PyComplex_Type"):
+		return p.AsComplex128(obj)
+	case p.hasTypeFlag(obj, Py_TPFLAGS_UNICODE_SUBCLASS):
+		return p.AsString(obj)
+	case p.hasTypeFlag(obj, Py_TPFLAGS_BYTES_SUBCLASS), p.isSubtype(obj, "PyByteArray_Type"):
+		return p.AsBytes(obj)
+	case p.hasTypeFlag(obj, Py_TPFLAGS_DICT_SUBCLASS):
+		m := map[string]any{}
+		if err := p.toMap(obj, reflect.ValueOf(&m).Elem()); err == nil {
+			return m, nil
+		}
+		mm := map[any]any{}
+		err := p.toMap(obj, reflect.ValueOf(&mm).Elem())
+		return mm, err
+	case p.hasTypeFlag(obj, Py_TPFLAGS_LIST_SUBCLASS|Py_TPFLAGS_TUPLE_SUBCLASS):
+		var s []any
+		err := p.toSlice(obj, reflect.ValueOf(&s).Elem())
+		return s, err
+	}
+	if v, ok, err := p.dateTimeToInterface(obj); ok {
+		return v, err
+	}
+	if v, ok := p.classValueOf(obj); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("no Go representation for %s: %w", p.GetTypeName(obj), ErrTypeError)
+}
+
+func (p *PythonLib) toSlice(obj PyObject, v reflect.Value) error {
+	if p.hasTypeFlag(obj, Py_TPFLAGS_UNICODE_SUBCLASS) {
+		return fmt.Errorf("cannot convert str to %s: %w", v.Type(), ErrTypeError)
+	}
+	it := p.Iterate(obj)
+	retv := reflect.MakeSlice(v.Type(), 0, 0)
+	for item := range it.All() {
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := p.toValue(item, elem); err != nil {
+			return err
+		}
+		retv = reflect.Append(retv, elem)
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	v.Set(retv)
+	return nil
+}
+
+func (p *PythonLib) toArray(obj PyObject, v reflect.Value) error {
+	s := reflect.New(reflect.SliceOf(v.Type().Elem())).Elem()
+	if err := p.toSlice(obj, s); err != nil {
+		return err
+	}
+	if s.Len() != v.Len() {
+		return fmt.Errorf("expected %d items, got %d: %w", v.Len(), s.Len(), ErrValueError)
+	}
+	reflect.Copy(v, s)
+	return nil
+}
+
+func (p *PythonLib) toMap(obj PyObject, v reflect.Value) error {
+	it := p.Iterate(obj)
+	retv := reflect.MakeMap(v.Type())
+	for key, value := range it.Items() {
+		k := reflect.New(v.Type().Key()).Elem()
+		if err := p.toValue(key, k); err != nil {
+			return err
+		}
+		if !k.Comparable() {
+			// a tuple key converted to any is a []any, which can't key a Go map
+			return fmt.Errorf("dict key %s is not a comparable Go map key: %w", p.ObjectToRepr(key), ErrTypeError)
+		}
+		val := reflect.New(v.Type().Elem()).Elem()
+		if err := p.toValue(value, val); err != nil {
+			return err
+		}
+		retv.SetMapIndex(k, val)
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	v.Set(retv)
+	return nil
+}
+
+// toStruct fills the exported fields of a struct from the matching keys of a mapping.
+func (p *PythonLib) toStruct(obj PyObject, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := pyFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		key := p.NewUnicode(name)
+		item := PyObject(p.Invoke("PyObject_GetItem", uintptr(obj), uintptr(key)))
+		p.DecRef(key)
+		if item == 0 {
+			err := p.FetchError()
+			if isPyError(err, ErrKeyError) {
+				// missing keys leave the field untouched
+				continue
+			}
+			return err
+		}
+		err := p.toValue(item, v.Field(i))
+		p.DecRef(item)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// isPyError reports whether err is a *PyError matching target
+func isPyError(err error, target *PyError) bool {
+	pyerr, ok := err.(*PyError)
+	return ok && pyerr.Is(target)
+}
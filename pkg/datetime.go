@@ -0,0 +1,419 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// CivilDate is a calendar date without a time zone, converted to and from datetime.date.
+type CivilDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// CivilTime is a time of day without a date or time zone, converted to and from
+// datetime.time.  Python keeps microseconds, so Nanosecond is truncated to a multiple of 1000.
+type CivilTime struct {
+	Hour       int
+	Minute     int
+	Second     int
+	Nanosecond int
+}
+
+// CivilDateTime is a date and time of day without a time zone, converted to and from naive
+// datetime.datetime objects.
+type CivilDateTime struct {
+	Date CivilDate
+	Time CivilTime
+}
+
+// pyDateTimeCAPI mirrors the C PyDateTime_CAPI struct exported by the datetime module through
+// the datetime.datetime_CAPI capsule.  The fields used here have been stable since 3.7.
+type pyDateTimeCAPI struct {
+	DateType     uintptr
+	DateTimeType uintptr
+	TimeType     uintptr
+	DeltaType    uintptr
+	TZInfoType   uintptr
+
+	TimeZone_UTC uintptr
+
+	// PyObject *(*Date_FromDate)(int, int, int, PyTypeObject*)
+	Date_FromDate uintptr
+	// PyObject *(*DateTime_FromDateAndTime)(int, int, int, int, int, int, int, PyObject*, PyTypeObject*)
+	DateTime_FromDateAndTime uintptr
+	// PyObject *(*Time_FromTime)(int, int, int, int, PyObject*, PyTypeObject*)
+	Time_FromTime uintptr
+	// PyObject *(*Delta_FromDelta)(int, int, int, int, PyTypeObject*)
+	Delta_FromDelta uintptr
+	// PyObject *(*TimeZone_FromTimeZone)(PyObject *offset, PyObject *name)
+	TimeZone_FromTimeZone uintptr
+}
+
+// dateTimeAPI imports the datetime C API capsule on first use.
+func (p *PythonLib) dateTimeAPI() (*pyDateTimeCAPI, error) {
+	p.dateTimeOnce.Do(func() {
+		name := p.StrToPtr("datetime.datetime_CAPI")
+		defer p.FreeString(name)
+		capi := p.Invoke("PyCapsule_Import", name, 0)
+		if capi == 0 {
+			p.dateTimeErr = p.FetchError()
+			return
+		}
+		p.dateTimeCAPI = (*pyDateTimeCAPI)(unsafe.Pointer(capi))
+	})
+	return p.dateTimeCAPI, p.dateTimeErr
+}
+
+// isInstance reports whether obj is an instance of the type t or a subclass of it.
+func (p *PythonLib) isInstance(obj PyObject, t uintptr) bool {
+	return int32(p.Invoke("PyType_IsSubtype", uintptr(p.typeOf(obj)), t)) != 0
+}
+
+// callNewObject calls one of the datetime constructor functions and returns its new reference.
+func (p *PythonLib) callNewObject(fn uintptr, args ...uintptr) (PyObject, error) {
+	r, _, _ := purego.SyscallN(fn, args...)
+	if r == 0 {
+		return 0, p.FetchError()
+	}
+	return PyObject(r), nil
+}
+
+// intAttrs reads int attributes of obj, e.g. the year, month and day of a date.
+func (p *PythonLib) intAttrs(obj PyObject, names ...string) ([]int, error) {
+	retv := make([]int, len(names))
+	for i, name := range names {
+		attr := p.GetAttrString(obj, name)
+		if attr == 0 {
+			return nil, p.FetchError()
+		}
+		v, err := p.AsInt64(attr)
+		p.DecRef(attr)
+		if err != nil {
+			return nil, err
+		}
+		retv[i] = int(v)
+	}
+	return retv, nil
+}
+
+// NewDateTime converts t to an aware datetime.datetime.  UTC maps to datetime.timezone.utc and
+// other locations to a fixed offset datetime.timezone named after the zone abbreviation, unless
+// DateTimeZoneInfo is set and zoneinfo knows the location's name.  Python keeps microseconds,
+// so the nanoseconds of t are truncated.
+func (p *PythonLib) NewDateTime(t time.Time) (PyObject, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return 0, err
+	}
+
+	loc := t.Location()
+	if loc == time.UTC {
+		return p.newDateTime(capi, t, capi.TimeZone_UTC)
+	}
+	if p.DateTimeZoneInfo && loc != time.Local {
+		if tz := p.zoneInfo(loc.String()); tz != 0 {
+			defer p.DecRef(tz)
+			// converting from UTC lets Python work out the fold of ambiguous wall times
+			utc, err := p.newDateTime(capi, t.UTC(), capi.TimeZone_UTC)
+			if err != nil {
+				return 0, err
+			}
+			defer p.DecRef(utc)
+			retv := p.CallMethod(utc, "astimezone", tz)
+			if retv == 0 {
+				return 0, p.FetchError()
+			}
+			return retv, nil
+		}
+	}
+
+	tz, err := p.newTimeZone(capi, t)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(tz)
+	return p.newDateTime(capi, t, uintptr(tz))
+}
+
+func (p *PythonLib) newDateTime(capi *pyDateTimeCAPI, t time.Time, tzinfo uintptr) (PyObject, error) {
+	return p.callNewObject(capi.DateTime_FromDateAndTime,
+		uintptr(t.Year()), uintptr(t.Month()), uintptr(t.Day()),
+		uintptr(t.Hour()), uintptr(t.Minute()), uintptr(t.Second()), uintptr(t.Nanosecond()/1000),
+		tzinfo, capi.DateTimeType)
+}
+
+// newTimeZone returns a fixed offset datetime.timezone for the zone of t.
+func (p *PythonLib) newTimeZone(capi *pyDateTimeCAPI, t time.Time) (PyObject, error) {
+	abbrev, offset := t.Zone()
+	delta, err := p.NewTimedelta(time.Duration(offset) * time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(delta)
+	var name PyObject
+	if abbrev != "" {
+		name = p.NewUnicode(abbrev)
+		defer p.DecRef(name)
+	}
+	return p.callNewObject(capi.TimeZone_FromTimeZone, uintptr(delta), uintptr(name))
+}
+
+// zoneInfo returns a new reference to zoneinfo.ZoneInfo(name), or 0 if zoneinfo is unavailable
+// or doesn't know the zone.
+func (p *PythonLib) zoneInfo(name string) PyObject {
+	module := p.ImportModule("zoneinfo")
+	if module == 0 {
+		p.Invoke("PyErr_Clear")
+		return 0
+	}
+	defer p.DecRef(module)
+	key := p.NewUnicode(name)
+	defer p.DecRef(key)
+	tz := p.CallMethod(module, "ZoneInfo", key)
+	if tz == 0 {
+		p.Invoke("PyErr_Clear")
+	}
+	return tz
+}
+
+// AsTime converts a datetime.datetime to a time.Time.  Aware datetimes keep their instant:
+// datetime.timezone.utc maps to time.UTC, zoneinfo zones to the Go location of the same name
+// when the Go time zone database has it, and any other tzinfo to a fixed zone with the offset
+// and name it reports.  Naive datetimes are taken to be local time, as datetime.timestamp()
+// does.
+func (p *PythonLib) AsTime(obj PyObject) (time.Time, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !p.isInstance(obj, capi.DateTimeType) {
+		return time.Time{}, fmt.Errorf("expected datetime, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	f, err := p.intAttrs(obj, "year", "month", "day", "hour", "minute", "second", "microsecond")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tzinfo := p.GetAttrString(obj, "tzinfo")
+	if tzinfo == 0 {
+		return time.Time{}, p.FetchError()
+	}
+	defer p.DecRef(tzinfo)
+	if p.IsNone(tzinfo) {
+		return time.Date(f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], f[6]*1000, time.Local), nil
+	}
+
+	// the offset honors the fold of ambiguous wall times
+	utcoffset := p.CallMethod(obj, "utcoffset")
+	if utcoffset == 0 {
+		return time.Time{}, p.FetchError()
+	}
+	offset, err := p.AsDuration(utcoffset)
+	p.DecRef(utcoffset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	instant := time.Date(f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], f[6]*1000, time.UTC).Add(-offset)
+
+	loc, err := p.locationOf(capi, obj, tzinfo, offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return instant.In(loc), nil
+}
+
+// locationOf maps the tzinfo of an aware datetime to a time.Location.
+func (p *PythonLib) locationOf(capi *pyDateTimeCAPI, dt PyObject, tzinfo PyObject, offset time.Duration) (*time.Location, error) {
+	if uintptr(tzinfo) == capi.TimeZone_UTC {
+		return time.UTC, nil
+	}
+
+	// zoneinfo.ZoneInfo has the IANA name as its key
+	if key := p.GetAttrString(tzinfo, "key"); key != 0 {
+		name, err := p.AsString(key)
+		p.DecRef(key)
+		if err == nil {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc, nil
+			}
+		}
+	}
+	p.Invoke("PyErr_Clear")
+
+	tzname := p.CallMethod(dt, "tzname")
+	if tzname == 0 {
+		return nil, p.FetchError()
+	}
+	defer p.DecRef(tzname)
+	var name string
+	if !p.IsNone(tzname) {
+		var err error
+		if name, err = p.AsString(tzname); err != nil {
+			return nil, err
+		}
+	}
+	if offset == 0 && name == "UTC" {
+		return time.UTC, nil
+	}
+	return time.FixedZone(name, int(offset/time.Second)), nil
+}
+
+// NewTimedelta converts d to a datetime.timedelta.  Python keeps microseconds, so d is
+// truncated to a whole number of microseconds.
+func (p *PythonLib) NewTimedelta(d time.Duration) (PyObject, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return 0, err
+	}
+	us := int64(d / time.Microsecond)
+	const usPerDay = 24 * 60 * 60 * 1000000
+	days, us := us/usPerDay, us%usPerDay
+	// normalize carries negative seconds and microseconds into the days
+	return p.callNewObject(capi.Delta_FromDelta,
+		uintptr(days), uintptr(us/1000000), uintptr(us%1000000), 1, capi.DeltaType)
+}
+
+// AsDuration converts a datetime.timedelta to a time.Duration.  Timedeltas beyond the roughly
+// 292 years a Duration can hold return an error wrapping ErrOverflowError.
+func (p *PythonLib) AsDuration(obj PyObject) (time.Duration, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return 0, err
+	}
+	if !p.isInstance(obj, capi.DeltaType) {
+		return 0, fmt.Errorf("expected timedelta, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	f, err := p.intAttrs(obj, "days", "seconds", "microseconds")
+	if err != nil {
+		return 0, err
+	}
+	// days is at most 999999999, so the microseconds of the seconds and microseconds parts
+	// can't overflow on their own
+	const maxDays = math.MaxInt64 / int64(24*time.Hour)
+	days := int64(f[0])
+	if days > maxDays || days < -maxDays {
+		return 0, fmt.Errorf("timedelta of %d days does not fit in time.Duration: %w", days, ErrOverflowError)
+	}
+	d := time.Duration(days) * 24 * time.Hour
+	rest := time.Duration(f[1])*time.Second + time.Duration(f[2])*time.Microsecond
+	if d > math.MaxInt64-rest {
+		return 0, fmt.Errorf("timedelta of %d days does not fit in time.Duration: %w", days, ErrOverflowError)
+	}
+	return d + rest, nil
+}
+
+// NewCivilDate converts d to a datetime.date.
+func (p *PythonLib) NewCivilDate(d CivilDate) (PyObject, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return 0, err
+	}
+	return p.callNewObject(capi.Date_FromDate, uintptr(d.Year), uintptr(d.Month), uintptr(d.Day), capi.DateType)
+}
+
+// AsCivilDate converts a datetime.date to a CivilDate.  A datetime.datetime is a date too and
+// gives its date part.
+func (p *PythonLib) AsCivilDate(obj PyObject) (CivilDate, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return CivilDate{}, err
+	}
+	if !p.isInstance(obj, capi.DateType) {
+		return CivilDate{}, fmt.Errorf("expected date, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	f, err := p.intAttrs(obj, "year", "month", "day")
+	if err != nil {
+		return CivilDate{}, err
+	}
+	return CivilDate{Year: f[0], Month: time.Month(f[1]), Day: f[2]}, nil
+}
+
+// NewCivilTime converts t to a naive datetime.time.
+func (p *PythonLib) NewCivilTime(t CivilTime) (PyObject, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return 0, err
+	}
+	return p.callNewObject(capi.Time_FromTime,
+		uintptr(t.Hour), uintptr(t.Minute), uintptr(t.Second), uintptr(t.Nanosecond/1000), p.PyNone, capi.TimeType)
+}
+
+// AsCivilTime converts a datetime.time to a CivilTime.  The tzinfo of an aware time is
+// ignored.
+func (p *PythonLib) AsCivilTime(obj PyObject) (CivilTime, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return CivilTime{}, err
+	}
+	if !p.isInstance(obj, capi.TimeType) {
+		return CivilTime{}, fmt.Errorf("expected time, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	f, err := p.intAttrs(obj, "hour", "minute", "second", "microsecond")
+	if err != nil {
+		return CivilTime{}, err
+	}
+	return CivilTime{Hour: f[0], Minute: f[1], Second: f[2], Nanosecond: f[3] * 1000}, nil
+}
+
+// NewCivilDateTime converts dt to a naive datetime.datetime.
+func (p *PythonLib) NewCivilDateTime(dt CivilDateTime) (PyObject, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return 0, err
+	}
+	d, t := dt.Date, dt.Time
+	return p.callNewObject(capi.DateTime_FromDateAndTime,
+		uintptr(d.Year), uintptr(d.Month), uintptr(d.Day),
+		uintptr(t.Hour), uintptr(t.Minute), uintptr(t.Second), uintptr(t.Nanosecond/1000),
+		p.PyNone, capi.DateTimeType)
+}
+
+// AsCivilDateTime converts a datetime.datetime to a CivilDateTime, the wall time it shows.  The
+// tzinfo of an aware datetime is ignored.
+func (p *PythonLib) AsCivilDateTime(obj PyObject) (CivilDateTime, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return CivilDateTime{}, err
+	}
+	if !p.isInstance(obj, capi.DateTimeType) {
+		return CivilDateTime{}, fmt.Errorf("expected datetime, got %s: %w", p.GetTypeName(obj), ErrTypeError)
+	}
+	f, err := p.intAttrs(obj, "year", "month", "day", "hour", "minute", "second", "microsecond")
+	if err != nil {
+		return CivilDateTime{}, err
+	}
+	return CivilDateTime{
+		Date: CivilDate{Year: f[0], Month: time.Month(f[1]), Day: f[2]},
+		Time: CivilTime{Hour: f[3], Minute: f[4], Second: f[5], Nanosecond: f[6] * 1000},
+	}, nil
+}
+
+// dateTimeToInterface converts datetime objects into their natural Go types for toInterface,
+// reporting false for anything else.
+func (p *PythonLib) dateTimeToInterface(obj PyObject) (any, bool, error) {
+	capi, err := p.dateTimeAPI()
+	if err != nil {
+		return nil, false, nil
+	}
+	switch {
+	case p.isInstance(obj, capi.DateTimeType):
+		v, err := p.AsTime(obj)
+		return v, true, err
+	case p.isInstance(obj, capi.DateType):
+		v, err := p.AsCivilDate(obj)
+		return v, true, err
+	case p.isInstance(obj, capi.TimeType):
+		v, err := p.AsCivilTime(obj)
+		return v, true, err
+	case p.isInstance(obj, capi.DeltaType):
+		v, err := p.AsDuration(obj)
+		return v, true, err
+	}
+	return nil, false, nil
+}
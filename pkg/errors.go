@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// PyTracebackFrame is a single frame of a Python traceback, outermost first.
+type PyTracebackFrame struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+	// Source is the stripped source line, if it could be read
+	Source string `json:"source,omitempty"`
+}
+
+// PyError is a Python exception captured as a Go error.  The exception is fully converted
+// to Go values when it is fetched, so a PyError holds no references to Python objects and
+// can outlive the interpreter state that raised it.
+type PyError struct {
+	// Type is the fully qualified name of the exception class, e.g. "KeyError" or "json.decoder.JSONDecodeError"
+	Type string `json:"type"`
+	// MRO lists the names of the exception class and all of its bases
+	MRO []string `json:"mro,omitempty"`
+	// Message is str() of the exception
+	Message string `json:"message"`
+	// Args is the repr() of each element of the exception's args tuple
+	Args      []string           `json:"args,omitempty"`
+	Traceback []PyTracebackFrame `json:"traceback,omitempty"`
+	// Cause is the explicit __cause__ (raise ... from ...) of the exception, if any
+	Cause error `json:"-"`
+	// Context is the implicit __context__ of the exception, if any and not suppressed
+	Context error `json:"-"`
+}
+
+func (e *PyError) Error() string {
+	if e.Message == "" {
+		return e.Type
+	}
+	return e.Type + ": " + e.Message
+}
+
+// Unwrap returns the cause and context of the exception so errors.Is and errors.As
+// can walk the Python exception chain.
+func (e *PyError) Unwrap() []error {
+	var retv []error
+	if e.Cause != nil {
+		retv = append(retv, e.Cause)
+	}
+	if e.Context != nil {
+		retv = append(retv, e.Context)
+	}
+	return retv
+}
+
+// Is reports whether target is a *PyError sentinel for the exception class of e or any of
+// its bases, so errors.Is(err, ErrLookupError) matches a KeyError the same way
+// "except LookupError" would.
+func (e *PyError) Is(target error) bool {
+	t, ok := target.(*PyError)
+	if !ok {
+		return false
+	}
+	if t.Type == e.Type {
+		return true
+	}
+	for _, name := range e.MRO {
+		if name == t.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatTraceback renders the exception in the same layout as the Python traceback module.
+func (e *PyError) FormatTraceback() string {
+	var sb strings.Builder
+	if len(e.Traceback) > 0 {
+		sb.WriteString("Traceback (most recent call last):\n")
+		for _, f := range e.Traceback {
+			fmt.Fprintf(&sb, "  File \"%s\", line %d, in %s\n", f.File, f.Line, f.Function)
+			if f.Source != "" {
+				fmt.Fprintf(&sb, "    %s\n", f.Source)
+			}
+		}
+	}
+	sb.WriteString(e.Error())
+	return sb.String()
+}
+
+// Sentinel errors for common built-in exceptions.  A fetched *PyError matches a sentinel with
+// errors.Is when the exception is an instance of that class or a subclass of it.
+var (
+	ErrBaseException       = &PyError{Type: "BaseException"}
+	ErrException           = &PyError{Type: "Exception"}
+	ErrArithmeticError     = &PyError{Type: "ArithmeticError"}
+	ErrAssertionError      = &PyError{Type: "AssertionError"}
+	ErrAttributeError      = &PyError{Type: "AttributeError"}
+	ErrBufferError         = &PyError{Type: "BufferError"}
+	ErrFileNotFoundError   = &PyError{Type: "FileNotFoundError"}
+	ErrImportError         = &PyError{Type: "ImportError"}
+	ErrIndexError          = &PyError{Type: "IndexError"}
+	ErrKeyError            = &PyError{Type: "KeyError"}
+	ErrKeyboardInterrupt   = &PyError{Type: "KeyboardInterrupt"}
+	ErrLookupError         = &PyError{Type: "LookupError"}
+	ErrMemoryError         = &PyError{Type: "MemoryError"}
+	ErrModuleNotFoundError = &PyError{Type: "ModuleNotFoundError"}
+	ErrNameError           = &PyError{Type: "NameError"}
+	ErrNotImplementedError = &PyError{Type: "NotImplementedError"}
+	ErrOSError             = &PyError{Type: "OSError"}
+	ErrOverflowError       = &PyError{Type: "OverflowError"}
+	ErrRuntimeError        = &PyError{Type: "RuntimeError"}
+	ErrStopAsyncIteration  = &PyError{Type: "StopAsyncIteration"}
+	ErrStopIteration       = &PyError{Type: "StopIteration"}
+	ErrSystemError         = &PyError{Type: "SystemError"}
+	ErrSystemExit          = &PyError{Type: "SystemExit"}
+	ErrTimeoutError        = &PyError{Type: "TimeoutError"}
+	ErrTypeError           = &PyError{Type: "TypeError"}
+	ErrUnicodeError        = &PyError{Type: "UnicodeError"}
+	ErrValueError          = &PyError{Type: "ValueError"}
+	ErrZeroDivisionError   = &PyError{Type: "ZeroDivisionError"}
+)
+
+// maxExceptionChain bounds how far __cause__ and __context__ are followed
+const maxExceptionChain = 32
+
+// HasFunction reports whether the named C API function was found in the loaded library.
+func (p *PythonLib) HasFunction(name string) bool {
+	return p.FTable[name] != nil
+}
+
+// ErrorOccurred reports whether the Python error indicator is set.
+func (p *PythonLib) ErrorOccurred() bool {
+	return p.Invoke("PyErr_Occurred") != 0
+}
+
+// FetchError retrieves and clears the current Python exception and returns it as a *PyError.
+// It returns nil if the error indicator is not set.
+func (p *PythonLib) FetchError() error {
+	exc := p.fetchException()
+	if exc == 0 {
+		return nil
+	}
+	defer p.DecRef(exc)
+	return p.NewPyError(exc)
+}
+
+// fetchException retrieves and clears the current exception, returning a new reference to a
+// normalized exception instance with its traceback attached, or 0 if no exception is set.
+func (p *PythonLib) fetchException() PyObject {
+	if p.Invoke("PyErr_Occurred") == 0 {
+		return 0
+	}
+
+	// 3.12 and later keep the exception normalized and expose it directly
+	if p.HasFunction("PyErr_GetRaisedException") {
+		return PyObject(p.Invoke("PyErr_GetRaisedException"))
+	}
+
+	// PyErr_Fetch and PyErr_NormalizeException take PyObject** out parameters.  Use memory
+	// from the python allocator so the addresses stay valid across the calls.
+	ptrsize := unsafe.Sizeof(uintptr(0))
+	out := p.Invoke("PyMem_Calloc", 3, ptrsize)
+	defer p.Invoke("PyMem_Free", out)
+	ptype, pvalue, ptb := out, out+ptrsize, out+2*ptrsize
+
+	p.Invoke("PyErr_Fetch", ptype, pvalue, ptb)
+	p.Invoke("PyErr_NormalizeException", ptype, pvalue, ptb)
+
+	t := *(*uintptr)(unsafe.Pointer(ptype))
+	v := *(*uintptr)(unsafe.Pointer(pvalue))
+	tb := *(*uintptr)(unsafe.Pointer(ptb))
+	if tb != 0 && v != 0 {
+		p.Invoke("PyException_SetTraceback", v, tb)
+	}
+	p.Invoke("Py_DecRef", t)
+	p.Invoke("Py_DecRef", tb)
+	return PyObject(v)
+}
+
+// NewPyError converts an exception instance into a *PyError, including its args, traceback
+// and __cause__/__context__ chain.  The exception reference is borrowed.
+func (p *PythonLib) NewPyError(exc PyObject) *PyError {
+	return p.newPyError(exc, make(map[PyObject]bool))
+}
+
+func (p *PythonLib) newPyError(exc PyObject, seen map[PyObject]bool) *PyError {
+	seen[exc] = true
+	retv := &PyError{
+		Type:    p.GetTypeName(exc),
+		MRO:     p.GetMRO(exc),
+		Message: p.ObjectToString(exc),
+	}
+
+	args := p.GetAttrString(exc, "args")
+	if args != 0 {
+		count := int(p.Invoke("PyTuple_Size", uintptr(args)))
+		for i := 0; i < count; i++ {
+			item := PyObject(p.Invoke("PyTuple_GetItem", uintptr(args), uintptr(i)))
+			retv.Args = append(retv.Args, p.ObjectToRepr(item))
+		}
+		p.DecRef(args)
+	}
+	p.Invoke("PyErr_Clear")
+
+	tb := PyObject(p.Invoke("PyException_GetTraceback", uintptr(exc)))
+	if tb != 0 {
+		retv.Traceback = p.extractTraceback(tb)
+		p.DecRef(tb)
+	}
+
+	if len(seen) >= maxExceptionChain {
+		return retv
+	}
+
+	cause := PyObject(p.Invoke("PyException_GetCause", uintptr(exc)))
+	if cause != 0 {
+		if !seen[cause] {
+			retv.Cause = p.newPyError(cause, seen)
+		}
+		p.DecRef(cause)
+	}
+
+	// like the traceback module, only report the context when it is not suppressed by an
+	// explicit "raise ... from ..."
+	suppress := p.GetAttrString(exc, "__suppress_context__")
+	suppressed := suppress != 0 && int32(p.Invoke("PyObject_IsTrue", uintptr(suppress))) == 1
+	p.DecRef(suppress)
+	p.Invoke("PyErr_Clear")
+	if !suppressed {
+		context := PyObject(p.Invoke("PyException_GetContext", uintptr(exc)))
+		if context != 0 {
+			if !seen[context] {
+				retv.Context = p.newPyError(context, seen)
+			}
+			p.DecRef(context)
+		}
+	}
+
+	return retv
+}
+
+// extractTraceback converts a traceback object into frames with traceback.extract_tb.
+func (p *PythonLib) extractTraceback(tb PyObject) []PyTracebackFrame {
+	traceback := p.ImportModule("traceback")
+	if traceback == 0 {
+		p.Invoke("PyErr_Clear")
+		return nil
+	}
+	defer p.DecRef(traceback)
+
+	summary := p.CallMethod(traceback, "extract_tb", tb)
+	if summary == 0 {
+		p.Invoke("PyErr_Clear")
+		return nil
+	}
+	defer p.DecRef(summary)
+
+	// StackSummary is a list of FrameSummary
+	count := int(p.Invoke("PyList_Size", uintptr(summary)))
+	retv := make([]PyTracebackFrame, 0, count)
+	for i := 0; i < count; i++ {
+		fs := PyObject(p.Invoke("PyList_GetItem", uintptr(summary), uintptr(i)))
+		frame := PyTracebackFrame{}
+
+		filename := p.GetAttrString(fs, "filename")
+		frame.File = p.UnicodeToString(filename)
+		p.DecRef(filename)
+
+		lineno := p.GetAttrString(fs, "lineno")
+		if lineno != 0 && lineno != PyObject(p.PyNone) {
+			frame.Line = int(p.Invoke("PyLong_AsLong", uintptr(lineno)))
+		}
+		p.DecRef(lineno)
+
+		name := p.GetAttrString(fs, "name")
+		frame.Function = p.UnicodeToString(name)
+		p.DecRef(name)
+
+		line := p.GetAttrString(fs, "line")
+		if line != PyObject(p.PyNone) {
+			frame.Source = strings.TrimSpace(p.UnicodeToString(line))
+		}
+		p.DecRef(line)
+
+		p.Invoke("PyErr_Clear")
+		retv = append(retv, frame)
+	}
+	return retv
+}
@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PyComplex mirrors the C Py_complex struct, which the complex number functions pass by value.
+type PyComplex struct {
+	Real float64
+	Imag float64
+}
+
+// InvokeStruct calls a C API function that takes or returns structs by value, which Invoke
+// can't.  Each argument is passed as its Go type: a Go struct is passed by value following
+// the platform ABI, and must have the layout of the C struct, such as PyComplex for
+// Py_complex.  ret is a pointer to a variable of the return type, or nil for void functions.
+// When the ctags describe the function, the arguments are checked against its parameters and
+// the struct sizes against the ctags layouts.
+//
+//	var c PyComplex
+//	err := lib.InvokeStruct("PyComplex_AsCComplex", &c, obj)
+func (p *PythonLib) InvokeStruct(name string, ret any, args ...any) error {
+	in := make([]reflect.Type, len(args))
+	values := make([]reflect.Value, len(args))
+	for i, a := range args {
+		if a == nil {
+			return fmt.Errorf("%s: argument %d is nil", name, i)
+		}
+		values[i] = reflect.ValueOf(a)
+		in[i] = values[i].Type()
+	}
+	var out []reflect.Type
+	var retv reflect.Value
+	if ret != nil {
+		retv = reflect.ValueOf(ret)
+		if retv.Kind() != reflect.Pointer || retv.IsNil() {
+			return fmt.Errorf("%s: return value must be a non-nil pointer, got %T", name, ret)
+		}
+		retv = retv.Elem()
+		out = []reflect.Type{retv.Type()}
+	}
+
+	if def, ok := p.FunctionDefs[name]; ok {
+		if err := p.checkStructCall(def, in, out); err != nil {
+			return err
+		}
+	}
+
+	fn, err := p.structFunc(name, reflect.FuncOf(in, out, false))
+	if err != nil {
+		return err
+	}
+	results := fn.Call(values)
+	if ret != nil {
+		retv.Set(results[0])
+	}
+	return nil
+}
+
+// structFunc binds the named function as fntype, caching the binding.
+func (p *PythonLib) structFunc(name string, fntype reflect.Type) (fn reflect.Value, err error) {
+	key := name + " " + fntype.String()
+	p.structFuncsMu.Lock()
+	defer p.structFuncsMu.Unlock()
+	if fn, ok := p.structFuncs[key]; ok {
+		return fn, nil
+	}
+
+	// purego panics on struct arguments the platform ABI support doesn't cover
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v: %w", name, r, ErrNotImplementedError)
+		}
+	}()
+	fptr := reflect.New(fntype)
+	if err := p.RegisterFunc(fptr.Interface(), name); err != nil {
+		return reflect.Value{}, err
+	}
+	if p.structFuncs == nil {
+		p.structFuncs = make(map[string]reflect.Value)
+	}
+	p.structFuncs[key] = fptr.Elem()
+	return fptr.Elem(), nil
+}
+
+// checkStructCall verifies the Go argument and return types agree with the ctags signature.
+func (p *PythonLib) checkStructCall(def PyFunction, in []reflect.Type, out []reflect.Type) error {
+	params := def.Parameters
+	if len(params) == 1 && params[0].Type == "void" {
+		params = nil
+	}
+	if len(in) != len(params) {
+		return fmt.Errorf("%s takes %d arguments, got %d: %w", def.Name, len(params), len(in), ErrTypeError)
+	}
+	for i, param := range params {
+		if err := p.checkStructType(def.Name, param.Type, in[i]); err != nil {
+			return err
+		}
+	}
+	if def.ReturnType == "void" {
+		if len(out) != 0 {
+			return fmt.Errorf("%s returns void, got a return value: %w", def.Name, ErrTypeError)
+		}
+		return nil
+	}
+	if len(out) == 1 {
+		return p.checkStructType(def.Name, def.ReturnType, out[0])
+	}
+	return nil
+}
+
+// checkStructType verifies a Go type can stand for the C type ctype: a struct of the same size
+// for a struct passed by value, and any scalar otherwise.
+func (p *PythonLib) checkStructType(fname string, ctype string, t reflect.Type) error {
+	ctype = strings.TrimPrefix(ctype, "const ")
+	layout, isStruct := p.CTags.PyStructs.Layout(ctype)
+	switch {
+	case isStruct && t.Kind() != reflect.Struct:
+		return fmt.Errorf("%s: %s is passed by value and needs a Go struct, got %s: %w", fname, ctype, t, ErrTypeError)
+	case isStruct && int(t.Size()) != layout.Size:
+		return fmt.Errorf("%s: %s is %d bytes, %s is %d: %w", fname, ctype, layout.Size, t, t.Size(), ErrTypeError)
+	}
+	if !isStruct && t.Kind() == reflect.Struct {
+		return fmt.Errorf("%s: %s is not a struct passed by value, got %s: %w", fname, ctype, t, ErrTypeError)
+	}
+	return nil
+}
+
+// NewComplex converts c to a Python complex.
+func (p *PythonLib) NewComplex(c complex128) (PyObject, error) {
+	var retv PyObject
+	if err := p.InvokeStruct("PyComplex_FromCComplex", &retv, PyComplex{Real: real(c), Imag: imag(c)}); err != nil {
+		return 0, err
+	}
+	if retv == 0 {
+		return 0, p.FetchError()
+	}
+	return retv, nil
+}
+
+// AsComplex128 converts a Python complex, or any object implementing __complex__, __float__
+// or __index__, to a complex128.
+func (p *PythonLib) AsComplex128(obj PyObject) (complex128, error) {
+	var c PyComplex
+	if err := p.InvokeStruct("PyComplex_AsCComplex", &c, obj); err != nil {
+		return 0, err
+	}
+	// -1.0 for the real part signals a possible error
+	if c.Real == -1 && p.ErrorOccurred() {
+		return 0, p.FetchError()
+	}
+	return complex(c.Real, c.Imag), nil
+}
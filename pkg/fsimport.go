@@ -0,0 +1,502 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Py_file_input is the start symbol of Py_CompileString for a module's source.
+const Py_file_input = 257
+
+// fsImportModule names the module holding the classes of the fs.FS importer
+const fsImportModule = "_kindalib_fs"
+
+// fsImporters owns the classes of the fs.FS importer, created with its module on first use
+type fsImporters struct {
+	once sync.Once
+	err  error
+}
+
+// fsImporter is a sys.meta_path finder, and the loader of the modules it finds, serving the
+// Python modules and packages of an fs.FS under a package prefix.
+type fsImporter struct {
+	lib    *PythonLib
+	prefix string
+	fsys   fs.FS
+	// the path the origins of the modules start with, e.g. "gofs:/plugins"
+	root string
+	// the Python object of the importer, borrowed, as it is the loader of its specs
+	obj PyObject
+}
+
+// fsModule is where a module was found in an fs.FS.
+type fsModule struct {
+	// the source file, or "" for a package directory without __init__.py
+	source string
+	// the directory of a package, or "" for a module
+	dir string
+}
+
+// fsResourceReader reads the data files of a package for importlib.resources.
+type fsResourceReader struct {
+	lib  *PythonLib
+	fsys fs.FS
+	dir  string
+}
+
+// fsTraversable is the importlib.resources Traversable of a file or directory of an fs.FS.
+type fsTraversable struct {
+	lib  *PythonLib
+	fsys fs.FS
+	name string
+}
+
+// MountFS makes the Python modules and packages in fsys importable under the package prefix,
+// so Python code can ship inside the Go binary with embed.FS.  With the prefix "plugins",
+// import plugins.tools loads tools.py or tools/__init__.py from the root of fsys, and
+// plugins itself is the package of the root directory.  An empty prefix mounts the modules
+// of fsys at the top level.  A directory without __init__.py is a package with no code.
+//
+// The importer is appended to sys.meta_path, after the finders of sys.path.  The modules get a
+// __file__ under "gofs:/" followed by their package path, get_source for tracebacks, and
+// importlib.resources reads the data files of the packages from fsys.
+//
+//	//go:embed python
+//	var pythonFiles embed.FS
+//
+//	sub, _ := fs.Sub(pythonFiles, "python")
+//	err := lib.MountFS("myapp", sub)
+func (p *PythonLib) MountFS(prefix string, fsys fs.FS) error {
+	f := &p.fsImporters
+	f.once.Do(func() { f.err = p.initFSImporters() })
+	if f.err != nil {
+		return f.err
+	}
+
+	imp := &fsImporter{lib: p, prefix: prefix, fsys: fsys, root: "gofs:/" + strings.ReplaceAll(prefix, ".", "/")}
+	obj, err := p.FromGo(imp)
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(obj)
+	imp.obj = obj
+
+	name := p.StrToPtr("meta_path")
+	defer p.FreeString(name)
+	metaPath := PyObject(p.Invoke("PySys_GetObject", name))
+	if metaPath == 0 {
+		return fmt.Errorf("sys.meta_path is missing: %w", ErrRuntimeError)
+	}
+	if int32(p.Invoke("PyList_Append", uintptr(metaPath), uintptr(obj))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// initFSImporters registers the module of the importer classes.
+func (p *PythonLib) initFSImporters() error {
+	notPublic := func() (*fsImporter, error) {
+		return nil, fmt.Errorf("FSImporter objects are created by MountFS: %w", ErrTypeError)
+	}
+	importer := p.NewClass("FSImporter", notPublic).
+		Doc("Finder and loader of the Python modules of a Go fs.FS.").
+		Method("find_spec", (*fsImporter).findSpec).
+		Method("create_module", (*fsImporter).createModule).
+		Method("exec_module", (*fsImporter).execModule).
+		Method("is_package", (*fsImporter).isPackage).
+		Method("get_source", (*fsImporter).getSource).
+		Method("get_resource_reader", (*fsImporter).getResourceReader).
+		Repr(func(f *fsImporter) string { return fmt.Sprintf("<FSImporter %q>", f.root) })
+
+	notPublicReader := func() (*fsResourceReader, error) {
+		return nil, fmt.Errorf("FSResourceReader objects are created by FSImporter: %w", ErrTypeError)
+	}
+	reader := p.NewClass("FSResourceReader", notPublicReader).
+		Doc("importlib.resources reader of the data files of a package in a Go fs.FS.").
+		Method("open_resource", (*fsResourceReader).openResource).
+		Method("resource_path", (*fsResourceReader).resourcePath).
+		Method("is_resource", (*fsResourceReader).isResource).
+		Method("contents", (*fsResourceReader).contents).
+		Method("files", (*fsResourceReader).files)
+
+	notPublicTraversable := func() (*fsTraversable, error) {
+		return nil, fmt.Errorf("FSTraversable objects are created by FSResourceReader: %w", ErrTypeError)
+	}
+	traversable := p.NewClass("FSTraversable", notPublicTraversable).
+		Doc("importlib.resources Traversable of a file or directory in a Go fs.FS.").
+		Method("iterdir", (*fsTraversable).iterdir).
+		Method("read_bytes", (*fsTraversable).readBytes).
+		Method("read_text", (*fsTraversable).readText, "encoding").
+		Method("is_dir", (*fsTraversable).isDir).
+		Method("is_file", (*fsTraversable).isFile).
+		Method("joinpath", (*fsTraversable).joinpath).
+		Method("open", (*fsTraversable).open, "mode", "encoding", "errors").
+		Property("name", func(t *fsTraversable) string { return path.Base(t.name) }, nil).
+		Repr(func(t *fsTraversable) string { return fmt.Sprintf("<FSTraversable %q>", t.name) })
+
+	module, err := p.NewModule(fsImportModule).Class(importer).Class(reader).Class(traversable).Register()
+	if err != nil {
+		return err
+	}
+	p.DecRef(module)
+	return nil
+}
+
+// locate finds the module fullname in the file system.
+func (f *fsImporter) locate(fullname string) (fsModule, bool) {
+	var rel string
+	switch {
+	case f.prefix == "":
+		rel = strings.ReplaceAll(fullname, ".", "/")
+	case fullname == f.prefix:
+		rel = "."
+	case strings.HasPrefix(fullname, f.prefix+"."):
+		rel = strings.ReplaceAll(fullname[len(f.prefix)+1:], ".", "/")
+	default:
+		return fsModule{}, false
+	}
+	if !fs.ValidPath(rel) {
+		return fsModule{}, false
+	}
+
+	if info, err := fs.Stat(f.fsys, rel); err == nil && info.IsDir() {
+		m := fsModule{dir: rel}
+		init := path.Join(rel, "__init__.py")
+		if info, err := fs.Stat(f.fsys, init); err == nil && !info.IsDir() {
+			m.source = init
+		}
+		return m, true
+	}
+	if rel == "." {
+		return fsModule{}, false
+	}
+	if info, err := fs.Stat(f.fsys, rel+".py"); err == nil && !info.IsDir() {
+		return fsModule{source: rel + ".py"}, true
+	}
+	return fsModule{}, false
+}
+
+// origin returns the location reported for a path of the file system.
+func (f *fsImporter) origin(name string) string {
+	return path.Join(f.root, name)
+}
+
+// findSpec is find_spec(fullname, path=None, target=None).
+func (f *fsImporter) findSpec(fullname string, _ ...PyObject) (PyObject, error) {
+	p := f.lib
+	m, ok := f.locate(fullname)
+	if !ok {
+		return 0, nil
+	}
+
+	machinery := p.ImportModule("importlib.machinery")
+	if machinery == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(machinery)
+	name := p.NewUnicode(fullname)
+	defer p.DecRef(name)
+	spec := p.CallMethod(machinery, "ModuleSpec", name, f.obj)
+	if spec == 0 {
+		return 0, p.FetchError()
+	}
+
+	attrs := map[string]any{}
+	if m.source != "" {
+		attrs["origin"] = f.origin(m.source)
+		attrs["has_location"] = true
+	}
+	if m.dir != "" {
+		attrs["submodule_search_locations"] = []string{f.origin(m.dir)}
+	}
+	for attr, v := range attrs {
+		value, err := p.FromGo(v)
+		if err == nil {
+			err = p.SetAttrString(spec, attr, value)
+			p.DecRef(value)
+		}
+		if err != nil {
+			p.DecRef(spec)
+			return 0, err
+		}
+	}
+	return spec, nil
+}
+
+// createModule is create_module(spec), which leaves creating the module to the import
+// system.
+func (f *fsImporter) createModule(spec PyObject) PyObject {
+	return 0
+}
+
+// execModule is exec_module(module): it runs the module's source in its namespace.
+func (f *fsImporter) execModule(module PyObject) error {
+	p := f.lib
+	pyname := p.GetAttrString(module, "__name__")
+	if pyname == 0 {
+		return p.FetchError()
+	}
+	name, err := p.AsString(pyname)
+	p.DecRef(pyname)
+	if err != nil {
+		return err
+	}
+	m, ok := f.locate(name)
+	if !ok {
+		return fmt.Errorf("module %s is no longer in %s: %w", name, f.root, ErrImportError)
+	}
+	if m.source == "" {
+		return nil
+	}
+	src, err := fs.ReadFile(f.fsys, m.source)
+	if err != nil {
+		return fmt.Errorf("%s: %v: %w", name, err, ErrImportError)
+	}
+
+	csrc := p.StrToPtr(string(src))
+	defer p.FreeString(csrc)
+	filename := p.StrToPtr(f.origin(m.source))
+	defer p.FreeString(filename)
+	code := p.Invoke("Py_CompileStringExFlags", csrc, filename, Py_file_input, 0, ^uintptr(0))
+	if code == 0 {
+		return p.FetchError()
+	}
+	defer p.Invoke("Py_DecRef", code)
+
+	// like exec, give the namespace the builtins before running the code in it
+	dict := p.Invoke("PyModule_GetDict", uintptr(module))
+	builtins := p.StrToPtr("__builtins__")
+	defer p.FreeString(builtins)
+	if p.Invoke("PyDict_GetItemString", dict, builtins) == 0 {
+		if int32(p.Invoke("PyDict_SetItemString", dict, builtins, p.Invoke("PyEval_GetBuiltins"))) != 0 {
+			return p.FetchError()
+		}
+	}
+	result := p.Invoke("PyEval_EvalCode", code, dict, dict)
+	if result == 0 {
+		return p.FetchError()
+	}
+	p.Invoke("Py_DecRef", result)
+	return nil
+}
+
+// isPackage is is_package(fullname).
+func (f *fsImporter) isPackage(fullname string) (bool, error) {
+	m, ok := f.locate(fullname)
+	if !ok {
+		return false, fmt.Errorf("no module named %s in %s: %w", fullname, f.root, ErrImportError)
+	}
+	return m.dir != "", nil
+}
+
+// getSource is get_source(fullname), which returns None for packages without __init__.py.
+func (f *fsImporter) getSource(fullname string) (*string, error) {
+	m, ok := f.locate(fullname)
+	if !ok {
+		return nil, fmt.Errorf("no module named %s in %s: %w", fullname, f.root, ErrImportError)
+	}
+	if m.source == "" {
+		return nil, nil
+	}
+	src, err := fs.ReadFile(f.fsys, m.source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", fullname, err, ErrImportError)
+	}
+	s := string(src)
+	return &s, nil
+}
+
+// getResourceReader is get_resource_reader(fullname), which returns None for modules that
+// are not packages.
+func (f *fsImporter) getResourceReader(fullname string) *fsResourceReader {
+	m, ok := f.locate(fullname)
+	if !ok || m.dir == "" {
+		return nil
+	}
+	return &fsResourceReader{lib: f.lib, fsys: f.fsys, dir: m.dir}
+}
+
+// resource returns the path of a data file of the package, which must be directly in the
+// package directory.
+func (r *fsResourceReader) resource(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return "", fmt.Errorf("%q is not a resource name: %w", name, ErrValueError)
+	}
+	return path.Join(r.dir, name), nil
+}
+
+// openResource is open_resource(resource), which returns a binary file object.
+func (r *fsResourceReader) openResource(resource string) (PyObject, error) {
+	name, err := r.resource(resource)
+	if err != nil {
+		return 0, err
+	}
+	rb := "rb"
+	return (&fsTraversable{lib: r.lib, fsys: r.fsys, name: name}).open(&rb, nil, nil)
+}
+
+// resourcePath is resource_path(resource).  The resources have no path in the real file
+// system, so importlib.resources.as_file copies them to a temporary file instead.
+func (r *fsResourceReader) resourcePath(resource string) (string, error) {
+	return "", fmt.Errorf("%s is not in the file system: %w", resource, ErrFileNotFoundError)
+}
+
+// isResource is is_resource(name), true for the files of the package directory.
+func (r *fsResourceReader) isResource(resource string) (bool, error) {
+	name, err := r.resource(resource)
+	if err != nil {
+		return false, nil
+	}
+	info, err := fs.Stat(r.fsys, name)
+	return err == nil && !info.IsDir(), nil
+}
+
+// contents is contents(), the names in the package directory.
+func (r *fsResourceReader) contents() ([]string, error) {
+	entries, err := fs.ReadDir(r.fsys, r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", r.dir, err, ErrOSError)
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, nil
+}
+
+// files is files(), the Traversable of the package directory.
+func (r *fsResourceReader) files() *fsTraversable {
+	return &fsTraversable{lib: r.lib, fsys: r.fsys, name: r.dir}
+}
+
+// iterdir is iterdir(), an iterator over the entries of the directory.
+func (t *fsTraversable) iterdir() (PyObject, error) {
+	p := t.lib
+	entries, err := fs.ReadDir(t.fsys, t.name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v: %w", t.name, err, ErrOSError)
+	}
+	children := make([]*fsTraversable, len(entries))
+	for i, e := range entries {
+		children[i] = &fsTraversable{lib: p, fsys: t.fsys, name: path.Join(t.name, e.Name())}
+	}
+	list, err := p.FromGo(children)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(list)
+	it := PyObject(p.Invoke("PyObject_GetIter", uintptr(list)))
+	if it == 0 {
+		return 0, p.FetchError()
+	}
+	return it, nil
+}
+
+// readBytes is read_bytes().
+func (t *fsTraversable) readBytes() ([]byte, error) {
+	data, err := fs.ReadFile(t.fsys, t.name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", t.name, ErrFileNotFoundError)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", t.name, err, ErrOSError)
+	}
+	return data, nil
+}
+
+// readText is read_text(encoding=None).
+func (t *fsTraversable) readText(encoding *string) (PyObject, error) {
+	p := t.lib
+	file, err := t.open(nil, encoding, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(file)
+	text := p.CallMethod(file, "read")
+	if text == 0 {
+		return 0, p.FetchError()
+	}
+	return text, nil
+}
+
+// isDir is is_dir().
+func (t *fsTraversable) isDir() bool {
+	info, err := fs.Stat(t.fsys, t.name)
+	return err == nil && info.IsDir()
+}
+
+// isFile is is_file().
+func (t *fsTraversable) isFile() bool {
+	info, err := fs.Stat(t.fsys, t.name)
+	return err == nil && !info.IsDir()
+}
+
+// joinpath is joinpath(*descendants), which takes names or slash-separated paths.
+func (t *fsTraversable) joinpath(descendants ...string) (*fsTraversable, error) {
+	name := path.Join(append([]string{t.name}, descendants...)...)
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%s is outside of the file system: %w", name, ErrValueError)
+	}
+	return &fsTraversable{lib: t.lib, fsys: t.fsys, name: name}, nil
+}
+
+// Div implements the / operator of Traversable as joinpath.
+func (t *fsTraversable) Div(other any) (any, error) {
+	child, ok := other.(string)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return t.joinpath(child)
+}
+
+// open is open(mode='r', encoding=None, errors=None), for the modes 'r' and 'rb'.
+func (t *fsTraversable) open(mode *string, encoding *string, errors *string) (PyObject, error) {
+	p := t.lib
+	m := "r"
+	if mode != nil {
+		m = *mode
+	}
+	if m != "r" && m != "rb" {
+		return 0, fmt.Errorf("invalid mode %q, resources are opened with 'r' or 'rb': %w", m, ErrValueError)
+	}
+	data, err := t.readBytes()
+	if err != nil {
+		return 0, err
+	}
+
+	io := p.ImportModule("io")
+	if io == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(io)
+	b := p.NewBytes(data)
+	defer p.DecRef(b)
+	file := p.CallMethod(io, "BytesIO", b)
+	if file == 0 {
+		return 0, p.FetchError()
+	}
+	if m == "rb" {
+		return file, nil
+	}
+	defer p.DecRef(file)
+
+	enc, err := p.FromGo(encoding)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(enc)
+	errs, err := p.FromGo(errors)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(errs)
+	text := p.CallMethod(io, "TextIOWrapper", file, enc, errs)
+	if text == 0 {
+		return 0, p.FetchError()
+	}
+	return text, nil
+}
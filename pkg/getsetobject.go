@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"unsafe"
+)
+
+// PyGetSetDefArray is the tp_getset table of a type, defining its computed attributes.
+// The last entry in the array must be a NULL entry.
+type PyGetSetDefArray struct {
+	Buffer    []byte
+	PyConfig  *PyConfig
+	PythonLib *PythonLib
+}
+
+// GetBuffer returns the address of the buffer as a uintptr
+func (p PyGetSetDefArray) GetBuffer() uintptr {
+	return uintptr(unsafe.Pointer(&p.Buffer[0]))
+}
+
+func (p *PythonLib) NewPyGetSetDefArray(count int) PyGetSetDefArray {
+	retv := PyGetSetDefArray{
+		PyConfig:  &p.CTags.PyStructs.PyGetSetDef,
+		PythonLib: p,
+	}
+	retv.Buffer = make([]byte, (count+1)*p.CTags.PyStructs.PyGetSetDef.Size)
+	return retv
+}
+
+// SetGetSetDef defines the attribute at index.  get is a PyObject *(PyObject *self, void
+// *closure) callback, and set an int (PyObject *self, PyObject *value, void *closure) callback
+// or 0 for a read-only attribute.  The doc may be empty.
+func (p PyGetSetDefArray) SetGetSetDef(index int, name string, get uintptr, set uintptr, doc string) {
+	indexoffset := index * p.PyConfig.Size
+	base := unsafe.Pointer(&p.Buffer[indexoffset])
+	field := func(member string) *uintptr {
+		return (*uintptr)(unsafe.Add(base, p.PyConfig.GetMemberOffset(member)))
+	}
+
+	*field("name") = p.PythonLib.StrToPtr(name)
+	*field("get") = get
+	*field("set") = set
+	*field("doc") = 0
+	if doc != "" {
+		*field("doc") = p.PythonLib.StrToPtr(doc)
+	}
+	*field("closure") = 0
+}
+
+// SetClosure sets the closure the get and set callbacks of the attribute at index receive,
+// which lets callbacks shared by several attributes tell them apart.
+func (p PyGetSetDefArray) SetClosure(index int, closure uintptr) {
+	base := unsafe.Pointer(&p.Buffer[index*p.PyConfig.Size])
+	*(*uintptr)(unsafe.Add(base, p.PyConfig.GetMemberOffset("closure"))) = closure
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import "runtime"
+
+// WithGIL runs fn holding the GIL, so goroutines other than the one that initialized the
+// interpreter can call into Python.  The goroutine is locked to its OS thread while fn runs,
+// as the Python thread state belongs to the thread.  It can be nested, and called by a thread
+// that already holds the GIL.
+func (p *PythonLib) WithGIL(fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	state := p.Invoke("PyGILState_Ensure")
+	defer p.Invoke("PyGILState_Release", state)
+	return fn()
+}
+
+// AllowThreads releases the GIL while fn runs, as Py_BEGIN_ALLOW_THREADS does, so Python
+// threads run while Go blocks.  The calling thread must hold the GIL, and fn must not call
+// into Python other than through WithGIL.
+func (p *PythonLib) AllowThreads(fn func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	state := p.Invoke("PyEval_SaveThread")
+	defer p.Invoke("PyEval_RestoreThread", state)
+	fn()
+}
+
+// waitWithoutGIL runs fn, which blocks, with the GIL released if the calling thread holds it.
+func (p *PythonLib) waitWithoutGIL(fn func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if int32(p.Invoke("PyGILState_Check")) != 0 {
+		p.AllowThreads(fn)
+		return
+	}
+	fn()
+}
@@ -0,0 +1,293 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+var selfType = reflect.TypeOf(Self(0))
+
+// Self is the object a Go function exposed to Python is bound to, the module for module
+// functions.  A function whose first parameter is a Self receives it as a borrowed reference,
+// and Python doesn't count it as an argument.
+type Self PyObject
+
+// goFunc adapts an ordinary Go function to the Python calling conventions.  Arguments are
+// converted with ToGo and the result with FromGo.  PyObject parameters receive the argument
+// itself as a borrowed reference, and a PyObject result is handed to Python as a new
+// reference.
+//
+// The function may return nothing, a value, an error, or a value and an error.  Trailing
+// pointer parameters are optional and nil when omitted, and a variadic parameter collects the
+// remaining positional arguments.  Arguments can be passed by keyword when argNames names the
+// parameters.  A context.Context parameter, after the Self or receiver if any, is not an
+// argument: it receives the context of the call.
+type goFunc struct {
+	lib        *PythonLib
+	name       string
+	fn         reflect.Value
+	hasSelf    bool
+	hasContext bool
+	// the function returns an awaitable and runs in a goroutine, see Async
+	async bool
+
+	// for methods of Go-backed classes, the receiver parameter and how to find its value
+	// from self
+	recv    reflect.Type
+	resolve func(self PyObject) (reflect.Value, error)
+
+	// the fixed parameters, the element type of a variadic parameter, and the number of
+	// parameters that must be given
+	params   []reflect.Type
+	variadic reflect.Type
+	required int
+	argNames []string
+
+	hasResult bool
+	hasError  bool
+}
+
+func (p *PythonLib) newGoFunc(name string, fn any, argNames []string) (*goFunc, error) {
+	return p.newGoMethod(name, fn, argNames, nil, nil)
+}
+
+// newGoMethod adapts a function whose first parameter, of type recv, is the Go value resolve
+// finds for self.
+func (p *PythonLib) newGoMethod(name string, fn any, argNames []string, recv reflect.Type, resolve func(PyObject) (reflect.Value, error)) (*goFunc, error) {
+	a, async := fn.(asyncFunc)
+	if async {
+		fn = a.fn
+	}
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("%s: expected a function, got %T: %w", name, fn, ErrTypeError)
+	}
+	t := v.Type()
+	f := &goFunc{lib: p, name: name, fn: v, argNames: argNames, recv: recv, resolve: resolve, async: async}
+
+	first, n := 0, t.NumIn()
+	switch {
+	case recv != nil:
+		if n == 0 || t.In(0) != recv {
+			return nil, fmt.Errorf("%s: the first parameter must be the receiver %s, got %s: %w", name, recv, t, ErrTypeError)
+		}
+		first = 1
+	case n > 0 && t.In(0) == selfType:
+		f.hasSelf = true
+		first = 1
+	}
+	if first < n && t.In(first) == contextType {
+		f.hasContext = true
+		first++
+	}
+	if t.IsVariadic() {
+		n--
+		f.variadic = t.In(n).Elem()
+	}
+	for i := first; i < n; i++ {
+		f.params = append(f.params, t.In(i))
+		if t.In(i).Kind() != reflect.Pointer {
+			f.required = len(f.params)
+		}
+	}
+	if len(argNames) != 0 && len(argNames) != len(f.params) {
+		return nil, fmt.Errorf("%s: %d argument names for %d parameters: %w", name, len(argNames), len(f.params), ErrValueError)
+	}
+
+	switch {
+	case t.NumOut() == 0:
+	case t.NumOut() == 1 && t.Out(0) == errorType:
+		f.hasError = true
+	case t.NumOut() == 1:
+		f.hasResult = true
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		f.hasResult, f.hasError = true, true
+	default:
+		return nil, fmt.Errorf("%s: a function must return at most a value and an error, got %s: %w", name, t, ErrTypeError)
+	}
+	if async {
+		if err := f.checkAsync(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// flags picks the cheapest calling convention that can pass the arguments.
+func (f *goFunc) flags() int {
+	switch {
+	case len(f.params) == 0 && f.variadic == nil:
+		return METH_NOARGS
+	case len(f.params) == 1 && f.required == 1 && f.variadic == nil && len(f.argNames) == 0:
+		return METH_O
+	}
+	return METH_FASTCALL | METH_KEYWORDS
+}
+
+// call calls the function with a METH_FASTCALL argument vector of borrowed references: the
+// nargs positional arguments followed by the values of the keyword arguments named in the
+// kwnames tuple.
+func (f *goFunc) call(self PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error) {
+	if f.async {
+		return f.callAsync(self, args, nargs, kwnames)
+	}
+	result, err := f.invoke(self, args, nargs, kwnames)
+	if err != nil || !result.IsValid() {
+		return 0, err
+	}
+	if result.Type() == pyObjectType {
+		return PyObject(result.Uint()), nil
+	}
+	return f.lib.fromValue(result)
+}
+
+// invoke calls the function like call, but returns its result as a Go value, which is not
+// valid for functions without a result.
+func (f *goFunc) invoke(self PyObject, args []PyObject, nargs int, kwnames PyObject) (reflect.Value, error) {
+	in, err := f.arguments(context.Background(), self, args, nargs, kwnames)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return f.result(f.fn.Call(in))
+}
+
+// arguments converts the arguments of a call into the arguments of the Go function, passing
+// ctx to a context.Context parameter.
+func (f *goFunc) arguments(ctx context.Context, self PyObject, args []PyObject, nargs int, kwnames PyObject) ([]reflect.Value, error) {
+	p := f.lib
+	if nargs > len(f.params) && f.variadic == nil {
+		return nil, fmt.Errorf("%s() takes at most %d arguments (%d given): %w", f.name, len(f.params), nargs, ErrTypeError)
+	}
+
+	// the arguments by parameter, 0 for those not given
+	given := make([]PyObject, len(f.params))
+	copy(given, args[:nargs])
+	for k, value := range args[nargs:] {
+		name, err := p.AsString(PyObject(p.Invoke("PyTuple_GetItem", uintptr(kwnames), uintptr(k))))
+		if err != nil {
+			return nil, err
+		}
+		i := f.argIndex(name)
+		if i < 0 {
+			return nil, fmt.Errorf("%s() got an unexpected keyword argument '%s': %w", f.name, name, ErrTypeError)
+		}
+		if given[i] != 0 {
+			return nil, fmt.Errorf("%s() got multiple values for argument '%s': %w", f.name, name, ErrTypeError)
+		}
+		given[i] = value
+	}
+
+	in := make([]reflect.Value, 0, len(f.params)+nargs+2)
+	if f.hasSelf {
+		in = append(in, reflect.ValueOf(Self(self)))
+	}
+	if f.recv != nil {
+		recv, err := f.resolve(self)
+		if err != nil {
+			return nil, err
+		}
+		in = append(in, recv)
+	}
+	if f.hasContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	for i, t := range f.params {
+		if given[i] == 0 {
+			if i < f.required {
+				return nil, fmt.Errorf("%s() missing required argument %s: %w", f.name, f.describeArg(i), ErrTypeError)
+			}
+			in = append(in, reflect.Zero(t))
+			continue
+		}
+		v, err := f.convertArg(given[i], t)
+		if err != nil {
+			return nil, fmt.Errorf("%s() argument %s: %w", f.name, f.describeArg(i), err)
+		}
+		in = append(in, v)
+	}
+	for i := len(f.params); i < nargs; i++ {
+		v, err := f.convertArg(args[i], f.variadic)
+		if err != nil {
+			return nil, fmt.Errorf("%s() argument %d: %w", f.name, i+1, err)
+		}
+		in = append(in, v)
+	}
+
+	return in, nil
+}
+
+// result returns the result of the Go function from its return values, or the error it
+// returned.
+func (f *goFunc) result(out []reflect.Value) (reflect.Value, error) {
+	if f.hasError {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	if !f.hasResult {
+		return reflect.Value{}, nil
+	}
+	return out[0], nil
+}
+
+// vectorArgs lays out an argument tuple and an optional keyword dict, as tp_init receives
+// them, as the argument vector of call.  The caller must release kwnames.
+func (p *PythonLib) vectorArgs(args PyObject, kwargs PyObject) (argv []PyObject, nargs int, kwnames PyObject, err error) {
+	nargs = int(p.Invoke("PyTuple_Size", uintptr(args)))
+	argv = make([]PyObject, nargs)
+	for i := range argv {
+		argv[i] = PyObject(p.Invoke("PyTuple_GetItem", uintptr(args), uintptr(i)))
+	}
+	if kwargs == 0 || int(p.Invoke("PyDict_Size", uintptr(kwargs))) == 0 {
+		return argv, nargs, 0, nil
+	}
+
+	keys := p.Invoke("PyDict_Keys", uintptr(kwargs))
+	if keys == 0 {
+		return nil, 0, 0, p.FetchError()
+	}
+	kwnames = PyObject(p.Invoke("PyList_AsTuple", keys))
+	p.Invoke("Py_DecRef", keys)
+	if kwnames == 0 {
+		return nil, 0, 0, p.FetchError()
+	}
+	n := int(p.Invoke("PyTuple_Size", uintptr(kwnames)))
+	for i := 0; i < n; i++ {
+		key := p.Invoke("PyTuple_GetItem", uintptr(kwnames), uintptr(i))
+		argv = append(argv, PyObject(p.Invoke("PyDict_GetItem", uintptr(kwargs), key)))
+	}
+	return argv, nargs, kwnames, nil
+}
+
+func (f *goFunc) convertArg(obj PyObject, t reflect.Type) (reflect.Value, error) {
+	if t == pyObjectType {
+		return reflect.ValueOf(obj), nil
+	}
+	v := reflect.New(t).Elem()
+	if err := f.lib.toValue(obj, v); err != nil {
+		return reflect.Value{}, err
+	}
+	return v, nil
+}
+
+func (f *goFunc) argIndex(name string) int {
+	for i, n := range f.argNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// describeArg names a parameter in error messages, by name if it has one.
+func (f *goFunc) describeArg(i int) string {
+	if len(f.argNames) != 0 {
+		return fmt.Sprintf("'%s' (pos %d)", f.argNames[i], i+1)
+	}
+	return fmt.Sprintf("%d", i+1)
+}
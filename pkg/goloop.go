@@ -0,0 +1,410 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// goLoopModule names the module holding the classes of the Go event loop
+const goLoopModule = "_kindalib_asyncio"
+
+// the events of the selectors module
+const (
+	selectorEventRead  = 1
+	selectorEventWrite = 2
+)
+
+// goLoops owns the classes of the Go event loop, created with their module on first use
+type goLoops struct {
+	once       sync.Once
+	err        error
+	loopType   PyObject
+	policyType PyObject
+}
+
+// goSelector is a selectors.BaseSelector whose select waits in Go: the file descriptors are
+// watched by Go's netpoller and the timeout is a Go timer, and the GIL is released meanwhile.
+// The file descriptors ready right away are found by a poll that doesn't block, and those the
+// netpoller doesn't watch, such as blocking pipes, are waited for by a goroutine in poll.
+type goSelector struct {
+	lib    *PythonLib
+	keys   map[int]*selectorKey
+	closed bool
+}
+
+// selectorKey is a registered file descriptor.
+type selectorKey struct {
+	fd     int
+	events int
+	// the selectors.SelectorKey returned to Python
+	key   PyObject
+	watch *fdWatch
+}
+
+// NewGoEventLoop returns a new reference to an asyncio event loop driven by Go.  It is a
+// subclass of asyncio.SelectorEventLoop, so it supports everything that loop does, whose
+// selector waits for the file descriptors with Go's netpoller and for the next timer with a
+// Go timer, without holding the GIL.  A Go program then has one scheduler: while the loop
+// waits, goroutines run and can call into Python, and call_soon_threadsafe wakes the loop
+// through the netpoller.
+//
+// On Python 3.12, asyncio.run(main(), loop_factory=...) runs a coroutine on it without
+// installing SetGoEventLoopPolicy.  The loop is not supported on Windows.
+func (p *PythonLib) NewGoEventLoop() (PyObject, error) {
+	g := &p.goLoops
+	g.once.Do(func() { g.err = p.initGoLoops() })
+	if g.err != nil {
+		return 0, g.err
+	}
+	selector, err := p.FromGo(&goSelector{lib: p, keys: map[int]*selectorKey{}})
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(selector)
+	loop := p.CallObject(g.loopType, selector)
+	if loop == 0 {
+		return 0, p.FetchError()
+	}
+	return loop, nil
+}
+
+// SetGoEventLoopPolicy installs an event loop policy whose new_event_loop returns a loop from
+// NewGoEventLoop, so asyncio.run and asyncio.new_event_loop run on Go.
+func (p *PythonLib) SetGoEventLoopPolicy() error {
+	g := &p.goLoops
+	g.once.Do(func() { g.err = p.initGoLoops() })
+	if g.err != nil {
+		return g.err
+	}
+	asyncio := p.ImportModule("asyncio")
+	if asyncio == 0 {
+		return p.FetchError()
+	}
+	defer p.DecRef(asyncio)
+	policy := p.CallObject(g.policyType)
+	if policy == 0 {
+		return p.FetchError()
+	}
+	defer p.DecRef(policy)
+	r := p.CallMethod(asyncio, "set_event_loop_policy", policy)
+	if r == 0 {
+		return p.FetchError()
+	}
+	p.DecRef(r)
+	return nil
+}
+
+// initGoLoops registers the module of the Go event loop, with the selector class and the loop
+// and policy classes derived from those of asyncio.
+func (p *PythonLib) initGoLoops() error {
+	g := &p.goLoops
+	selector := p.NewClass("GoSelector", func() (*goSelector, error) {
+		return &goSelector{lib: p, keys: map[int]*selectorKey{}}, nil
+	}).
+		Doc("Selector waiting for file descriptors with Go's netpoller.").
+		Method("register", (*goSelector).register).
+		Method("unregister", (*goSelector).unregister).
+		Method("modify", (*goSelector).modify).
+		Method("select", (*goSelector).selectEvents).
+		Method("get_key", (*goSelector).getKey).
+		Method("get_map", (*goSelector).getMap).
+		Method("close", (*goSelector).close)
+
+	module, err := p.NewModule(goLoopModule).Class(selector).Register()
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(module)
+
+	asyncio := p.ImportModule("asyncio")
+	if asyncio == 0 {
+		return p.FetchError()
+	}
+	defer p.DecRef(asyncio)
+
+	newLoop, err := p.NewCallable(func(args ...PyObject) (PyObject, error) {
+		return p.NewGoEventLoop()
+	})
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(newLoop)
+
+	if g.loopType, err = p.deriveClass(module, asyncio, "GoEventLoop", "SelectorEventLoop",
+		"asyncio event loop driven by Go's scheduler.", nil); err != nil {
+		return err
+	}
+	// a builtin function is not bound as a method, so new_event_loop() is called without self
+	if g.policyType, err = p.deriveClass(module, asyncio, "GoEventLoopPolicy", "DefaultEventLoopPolicy",
+		"asyncio event loop policy creating Go event loops.", map[string]PyObject{"new_event_loop": newLoop}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// deriveClass creates the class name, deriving from the class base of the module from, with
+// the attributes attrs, and adds it to module.  It returns a new reference to the class.
+func (p *PythonLib) deriveClass(module PyObject, from PyObject, name string, base string, doc string, attrs map[string]PyObject) (PyObject, error) {
+	baseType := p.GetAttrString(from, base)
+	if baseType == 0 {
+		return 0, p.FetchError()
+	}
+	bases, err := p.NewTuple(baseType)
+	p.DecRef(baseType)
+	if err != nil {
+		return 0, err
+	}
+	defer bases.Release()
+
+	dict := p.NewDictBuilder().Set("__module__", goLoopModule).Set("__doc__", doc)
+	for k, v := range attrs {
+		dict.Set(k, v)
+	}
+	ns, err := dict.Build()
+	if err != nil {
+		return 0, err
+	}
+	defer ns.Release()
+
+	pyname := p.NewUnicode(name)
+	defer p.DecRef(pyname)
+	typeType := PyObject(p.PyData["PyType_Type"])
+	class := p.CallObject(typeType, pyname, bases.Object(), ns.Object())
+	if class == 0 {
+		return 0, p.FetchError()
+	}
+	if err := p.SetAttrString(module, name, class); err != nil {
+		p.DecRef(class)
+		return 0, err
+	}
+	return class, nil
+}
+
+// fileDescriptor returns the file descriptor of fileobj, an int or an object with fileno().
+func (s *goSelector) fileDescriptor(fileobj PyObject) (int, error) {
+	p := s.lib
+	fd := int(int32(p.Invoke("PyObject_AsFileDescriptor", uintptr(fileobj))))
+	if fd < 0 {
+		return 0, p.FetchError()
+	}
+	return fd, nil
+}
+
+// lookup returns the key of fileobj, failing with KeyError if it is not registered.
+func (s *goSelector) lookup(fileobj PyObject) (*selectorKey, error) {
+	if s.closed {
+		return nil, fmt.Errorf("Selector is closed: %w", ErrRuntimeError)
+	}
+	fd, err := s.fileDescriptor(fileobj)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := s.keys[fd]
+	if !ok {
+		return nil, fmt.Errorf("%s is not registered: %w", s.lib.ObjectToRepr(fileobj), ErrKeyError)
+	}
+	return k, nil
+}
+
+// register is register(fileobj, events, data=None), returning the new SelectorKey.
+func (s *goSelector) register(fileobj PyObject, events int, data ...PyObject) (PyObject, error) {
+	p := s.lib
+	if s.closed {
+		return 0, fmt.Errorf("Selector is closed: %w", ErrRuntimeError)
+	}
+	if events == 0 || events&^(selectorEventRead|selectorEventWrite) != 0 {
+		return 0, fmt.Errorf("Invalid events: %#x: %w", events, ErrValueError)
+	}
+	if len(data) > 1 {
+		return 0, fmt.Errorf("register() takes at most 3 arguments (%d given): %w", len(data)+2, ErrTypeError)
+	}
+	fd, err := s.fileDescriptor(fileobj)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := s.keys[fd]; ok {
+		return 0, fmt.Errorf("%s (FD %d) is already registered: %w", p.ObjectToRepr(fileobj), fd, ErrKeyError)
+	}
+
+	watch, err := newFDWatch(fd)
+	if err != nil {
+		return 0, err
+	}
+	k := &selectorKey{fd: fd, events: events, watch: watch}
+	if k.key, err = s.newKey(fileobj, fd, events, data); err != nil {
+		watch.close()
+		return 0, err
+	}
+	s.keys[fd] = k
+	p.IncRef(k.key)
+	return k.key, nil
+}
+
+// newKey returns a new reference to a selectors.SelectorKey.
+func (s *goSelector) newKey(fileobj PyObject, fd int, events int, data []PyObject) (PyObject, error) {
+	p := s.lib
+	selectors := p.ImportModule("selectors")
+	if selectors == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(selectors)
+	var d PyObject
+	if len(data) != 0 {
+		d = data[0]
+	}
+	args, err := p.NewTuple(fileobj, fd, events, d)
+	if err != nil {
+		return 0, err
+	}
+	defer args.Release()
+	keyType := p.GetAttrString(selectors, "SelectorKey")
+	if keyType == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(keyType)
+	key := PyObject(p.Invoke("PyObject_CallObject", uintptr(keyType), uintptr(args.Object())))
+	if key == 0 {
+		return 0, p.FetchError()
+	}
+	return key, nil
+}
+
+// unregister is unregister(fileobj), returning the SelectorKey of fileobj.
+func (s *goSelector) unregister(fileobj PyObject) (PyObject, error) {
+	k, err := s.lookup(fileobj)
+	if err != nil {
+		return 0, err
+	}
+	delete(s.keys, k.fd)
+	k.watch.close()
+	// the reference of the map becomes the result
+	return k.key, nil
+}
+
+// modify is modify(fileobj, events, data=None), returning the new SelectorKey.
+func (s *goSelector) modify(fileobj PyObject, events int, data ...PyObject) (PyObject, error) {
+	p := s.lib
+	k, err := s.lookup(fileobj)
+	if err != nil {
+		return 0, err
+	}
+	if events == 0 || events&^(selectorEventRead|selectorEventWrite) != 0 {
+		return 0, fmt.Errorf("Invalid events: %#x: %w", events, ErrValueError)
+	}
+	key, err := s.newKey(fileobj, k.fd, events, data)
+	if err != nil {
+		return 0, err
+	}
+	p.DecRef(k.key)
+	k.key = key
+	k.events = events
+	p.IncRef(key)
+	return key, nil
+}
+
+// getKey is get_key(fileobj).
+func (s *goSelector) getKey(fileobj PyObject) (PyObject, error) {
+	k, err := s.lookup(fileobj)
+	if err != nil {
+		return 0, err
+	}
+	s.lib.IncRef(k.key)
+	return k.key, nil
+}
+
+// getMap is get_map(), returning a dict of the keys by file descriptor, or None once the
+// selector is closed.
+func (s *goSelector) getMap() (PyObject, error) {
+	p := s.lib
+	if s.closed {
+		return p.NewNone(), nil
+	}
+	b := p.NewDictBuilder()
+	for fd, k := range s.keys {
+		b.Set(fd, k.key)
+	}
+	d, err := b.Build()
+	if err != nil {
+		return 0, err
+	}
+	defer d.Release()
+	p.IncRef(d.Object())
+	return d.Object(), nil
+}
+
+// close is close(), unregistering every file descriptor.
+func (s *goSelector) close() {
+	for fd, k := range s.keys {
+		k.watch.close()
+		s.lib.DecRef(k.key)
+		delete(s.keys, fd)
+	}
+	s.closed = true
+}
+
+// selectEvents is select(timeout=None), returning the list of the (key, events) of the file
+// descriptors that are ready.  It waits until one is, or for timeout seconds unless timeout
+// is None, and doesn't wait if timeout is not positive.
+func (s *goSelector) selectEvents(args ...PyObject) (PyObject, error) {
+	p := s.lib
+	if s.closed {
+		return 0, fmt.Errorf("Selector is closed: %w", ErrRuntimeError)
+	}
+	if len(args) > 1 {
+		return 0, fmt.Errorf("select() takes at most 1 argument (%d given): %w", len(args), ErrTypeError)
+	}
+	timeout := time.Duration(-1)
+	if len(args) == 1 && !p.IsNone(args[0]) {
+		seconds, err := p.AsFloat64(args[0])
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case seconds <= 0:
+			timeout = 0
+		case seconds >= math.MaxInt64/float64(time.Second):
+			timeout = -1
+		default:
+			timeout = time.Duration(math.Ceil(seconds * float64(time.Second)))
+		}
+	}
+
+	keys := make([]*selectorKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	ready, err := pollFDs(keys)
+	if err == nil && !slices.ContainsFunc(ready, func(events int) bool { return events != 0 }) && timeout != 0 {
+		p.waitWithoutGIL(func() { waitFDs(keys, timeout) })
+		ready, err = pollFDs(keys)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	b := p.NewListBuilder(len(ready))
+	for i, events := range ready {
+		if events == 0 {
+			continue
+		}
+		event, err := p.NewTuple(keys[i].key, events)
+		if err != nil {
+			if list, err := b.Build(); err == nil {
+				list.Release()
+			}
+			return 0, err
+		}
+		b.Append(event)
+		event.Release()
+	}
+	list, err := b.Build()
+	if err != nil {
+		return 0, err
+	}
+	defer list.Release()
+	p.IncRef(list.Object())
+	return list.Object(), nil
+}
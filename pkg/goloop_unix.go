@@ -0,0 +1,209 @@
+//go:build darwin || freebsd || linux
+
+package pkg
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdWatch waits for a file descriptor with the netpoller, through a duplicate of it: the
+// duplicate is closed with the watch, and the caller keeps the original.
+type fdWatch struct {
+	file *os.File
+	conn syscall.RawConn
+	fd   int
+	// whether the netpoller watches the file descriptor.  It doesn't watch blocking file
+	// descriptors, such as a terminal or a pipe the program opened itself, nor regular files,
+	// which waitFDs waits for with poll instead.
+	pollable bool
+}
+
+func newFDWatch(fd int) (*fdWatch, error) {
+	dup, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	// os.NewFile adds a non-blocking file descriptor to the netpoller, unless it is a regular
+	// file.  Making the duplicate non-blocking would change the original, as they share the
+	// file status flags.
+	file := os.NewFile(uintptr(dup), "fd")
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// only the files of the netpoller have deadlines
+	pollable := file.SetDeadline(time.Time{}) == nil
+	return &fdWatch{file: file, conn: conn, fd: dup, pollable: pollable}, nil
+}
+
+func (w *fdWatch) close() {
+	w.file.Close()
+}
+
+// pollFDs returns the events of keys that are ready now, by key.
+func pollFDs(keys []*selectorKey) ([]int, error) {
+	fds := make([]unix.PollFd, len(keys))
+	for i, k := range keys {
+		fds[i].Fd = int32(k.fd)
+		if k.events&selectorEventRead != 0 {
+			fds[i].Events |= unix.POLLIN
+		}
+		if k.events&selectorEventWrite != 0 {
+			fds[i].Events |= unix.POLLOUT
+		}
+	}
+	for {
+		_, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, os.NewSyscallError("poll", err)
+		}
+		break
+	}
+
+	ready := make([]int, len(keys))
+	for i, k := range keys {
+		// an error or hang up is reported as the events the key waits for, whose read or
+		// write then fails
+		if fds[i].Revents&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) != 0 {
+			ready[i] = k.events
+			continue
+		}
+		if fds[i].Revents&unix.POLLIN != 0 {
+			ready[i] |= selectorEventRead
+		}
+		if fds[i].Revents&unix.POLLOUT != 0 {
+			ready[i] |= selectorEventWrite
+		}
+	}
+	return ready, nil
+}
+
+// isReady reports whether fd is ready for events, without blocking.
+func isReady(fd uintptr, events int16) bool {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: events}}
+	n, err := unix.Poll(fds, 0)
+	return err != nil || n != 0
+}
+
+// waitFDs blocks until one of the keys is ready or for timeout, forever if it is negative.
+// Every file descriptor of the netpoller is waited for by a goroutine parked in it, and the
+// others are woken by moving their deadline when one is ready.  The other file descriptors
+// are waited for together by a goroutine blocked in poll, which is woken through a pipe.
+func waitFDs(keys []*selectorKey, timeout time.Duration) {
+	done := make(chan struct{}, 2*len(keys)+1)
+	var wg sync.WaitGroup
+	wait := func(fn func(func(uintptr) bool) error, events int16) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the netpoller is edge triggered, so it is only waited for when the file
+			// descriptor is not ready, as Go does after EAGAIN
+			fn(func(fd uintptr) bool { return isReady(fd, events) })
+			done <- struct{}{}
+		}()
+	}
+	var unpollable []*selectorKey
+	for _, k := range keys {
+		if !k.watch.pollable {
+			unpollable = append(unpollable, k)
+			continue
+		}
+		if k.events&selectorEventRead != 0 {
+			wait(k.watch.conn.Read, unix.POLLIN)
+		}
+		if k.events&selectorEventWrite != 0 {
+			wait(k.watch.conn.Write, unix.POLLOUT)
+		}
+	}
+
+	var wake [2]int
+	woken := false
+	if len(unpollable) != 0 {
+		if err := newWakePipe(&wake); err == nil {
+			woken = true
+			defer unix.Close(wake[0])
+			defer unix.Close(wake[1])
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				waitUnpollable(unpollable, wake[0])
+				done <- struct{}{}
+			}()
+		} else if timeout < 0 || timeout > unpollableInterval {
+			// without a pipe, the file descriptors are polled again after a while
+			timeout = unpollableInterval
+		}
+	}
+
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-done:
+	case <-expired:
+	}
+
+	for _, k := range keys {
+		if k.watch.pollable {
+			k.watch.file.SetDeadline(time.Unix(1, 0))
+		}
+	}
+	if woken {
+		unix.Write(wake[1], []byte{0})
+	}
+	wg.Wait()
+	for _, k := range keys {
+		if k.watch.pollable {
+			k.watch.file.SetDeadline(time.Time{})
+		}
+	}
+}
+
+// newWakePipe creates a pipe that is closed on exec, as os.Pipe does on the systems without
+// pipe2.
+func newWakePipe(fds *[2]int) error {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	if err := unix.Pipe(fds[:]); err != nil {
+		return os.NewSyscallError("pipe", err)
+	}
+	unix.CloseOnExec(fds[0])
+	unix.CloseOnExec(fds[1])
+	return nil
+}
+
+// unpollableInterval is how often waitFDs polls the file descriptors the netpoller doesn't
+// watch when it can't create the pipe that wakes the goroutine waiting for them.
+const unpollableInterval = 10 * time.Millisecond
+
+// waitUnpollable blocks in poll until one of the keys or the wake file descriptor is ready.
+func waitUnpollable(keys []*selectorKey, wake int) {
+	fds := make([]unix.PollFd, len(keys)+1)
+	for i, k := range keys {
+		fds[i].Fd = int32(k.watch.fd)
+		if k.events&selectorEventRead != 0 {
+			fds[i].Events |= unix.POLLIN
+		}
+		if k.events&selectorEventWrite != 0 {
+			fds[i].Events |= unix.POLLOUT
+		}
+	}
+	fds[len(keys)] = unix.PollFd{Fd: int32(wake), Events: unix.POLLIN}
+	for {
+		if _, err := unix.Poll(fds, -1); err != unix.EINTR {
+			return
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package pkg
+
+import (
+	"fmt"
+	"time"
+)
+
+// fdWatch is not available on Windows, where the Go event loop is not supported.
+type fdWatch struct{}
+
+func newFDWatch(fd int) (*fdWatch, error) {
+	return nil, fmt.Errorf("the Go event loop is not supported on Windows: %w", ErrNotImplementedError)
+}
+
+func (w *fdWatch) close() {}
+
+func pollFDs(keys []*selectorKey) ([]int, error) {
+	return make([]int, len(keys)), nil
+}
+
+func waitFDs(keys []*selectorKey, timeout time.Duration) {
+	if timeout >= 0 {
+		time.Sleep(timeout)
+	}
+}
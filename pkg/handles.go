@@ -0,0 +1,38 @@
+package pkg
+
+import "sync"
+
+// handleTable hands out integer handles for Go values that C memory refers to, as C memory
+// can't hold Go pointers.  Handles start at 1, so 0 is never a valid handle.
+type handleTable struct {
+	mu     sync.Mutex
+	values map[uintptr]any
+	next   uintptr
+}
+
+func (t *handleTable) add(v any) uintptr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.values == nil {
+		t.values = make(map[uintptr]any)
+	}
+	t.next++
+	t.values[t.next] = v
+	return t.next
+}
+
+func (t *handleTable) get(h uintptr) (any, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.values[h]
+	return v, ok
+}
+
+// remove drops a handle and returns its value.
+func (t *handleTable) remove(h uintptr) any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v := t.values[h]
+	delete(t.values, h)
+	return v
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// builtinFinder finds the dotted builtin modules of the inittab, which the standard
+// BuiltinImporter only finds at the top level before Python 3.12.
+type builtinFinder struct {
+	lib *PythonLib
+}
+
+// builtinFinders owns the class of the finder, created on first use
+type builtinFinders struct {
+	once  sync.Once
+	err   error
+	class *ClassBuilder
+}
+
+// AppendInittab adds the module to the table of built-in modules, so it is a real builtin:
+// it is listed in sys.builtin_module_names, can be imported while the interpreter starts, as
+// from sitecustomize, and every interpreter creates its own module object from the
+// definition.  It must be called before Init.
+//
+// A dotted name adds a submodule.  Parent packages that have not been added are added as
+// empty packages, and a module with submodules in the table is a package.  A parent can still
+// be added after its submodules, replacing the empty package.
+//
+//	err := lib.NewModule("myapp.native").Func("version", version).AppendInittab()
+func (b *ModuleBuilder) AppendInittab() error {
+	p := b.lib
+	if b.err != nil {
+		return b.err
+	}
+	if p.Invoke("Py_IsInitialized") != 0 {
+		return fmt.Errorf("%s: AppendInittab must be called before the interpreter is initialized: %w", b.name, ErrRuntimeError)
+	}
+	if i := p.inittabIndex(b.name); i >= 0 {
+		placeholder := p.inittab[i]
+		if !placeholder.inittabPlaceholder {
+			return fmt.Errorf("%s is already in the inittab: %w", b.name, ErrValueError)
+		}
+		// the inittab keeps the name of the placeholder, and its PyInit function finds the
+		// builder by name
+		b.inittabName = placeholder.inittabName
+		p.inittab[i] = b
+		return nil
+	}
+
+	modules := []*ModuleBuilder{b}
+	parts := strings.Split(b.name, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		parent := strings.Join(parts[:i], ".")
+		if p.inittabModule(parent) == nil {
+			m := p.NewModule(parent)
+			m.inittabPlaceholder = true
+			modules = append(modules, m)
+		}
+	}
+	for _, m := range modules {
+		// the inittab keeps a pointer to the name, and PyMem_Malloc can't be used before the
+		// interpreter is initialized, so the builder holds it
+		m.inittabName = append([]byte(m.name), 0)
+		name := m.name
+		initModule := func() uintptr { return p.inittabModule(name).initModule() }
+		if int32(p.Invoke("PyImport_AppendInittab", uintptr(unsafe.Pointer(&m.inittabName[0])), purego.NewCallback(initModule))) != 0 {
+			return fmt.Errorf("%s: could not extend the inittab: %w", m.name, ErrMemoryError)
+		}
+		p.inittab = append(p.inittab, m)
+	}
+	return nil
+}
+
+// initModule is the PyInit function of a module of the inittab.  It returns the multi-phase
+// definition, from which the import system creates and executes the module.
+func (b *ModuleBuilder) initModule() (retv uintptr) {
+	p := b.lib
+	defer p.recoverCallback(&retv, 0)
+	def, err := b.definition()
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	return def.def.GetBuffer()
+}
+
+// inittabModule returns the module of the inittab named name, or nil.
+func (p *PythonLib) inittabModule(name string) *ModuleBuilder {
+	if i := p.inittabIndex(name); i >= 0 {
+		return p.inittab[i]
+	}
+	return nil
+}
+
+// inittabIndex returns the index in p.inittab of the module named name, or -1.
+func (p *PythonLib) inittabIndex(name string) int {
+	for i, m := range p.inittab {
+		if m.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasInittabSubmodules reports whether the inittab has submodules of the package name.
+func (p *PythonLib) hasInittabSubmodules(name string) bool {
+	for _, m := range p.inittab {
+		if strings.HasPrefix(m.name, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// setupInittabPackage makes a module of the inittab with submodules a package, and makes
+// sure the interpreter can find the submodules.
+func (p *PythonLib) setupInittabPackage(module PyObject) error {
+	path := PyObject(p.Invoke("PyList_New", 0))
+	if path == 0 {
+		return p.FetchError()
+	}
+	err := p.SetAttrString(module, "__path__", path)
+	p.DecRef(path)
+	if err != nil {
+		return err
+	}
+	return p.installBuiltinFinder()
+}
+
+// installBuiltinFinder inserts a builtinFinder at the start of sys.meta_path, unless the
+// interpreter has one.
+func (p *PythonLib) installBuiltinFinder() error {
+	f := &p.builtinFinders
+	f.once.Do(func() {
+		f.class = p.NewClass("BuiltinSubmoduleFinder", func() (*builtinFinder, error) {
+			return nil, fmt.Errorf("BuiltinSubmoduleFinder objects are created by AppendInittab: %w", ErrTypeError)
+		}).
+			Doc("Finder of the built-in submodules defined in Go.").
+			Method("find_spec", (*builtinFinder).findSpec)
+		var t PyObject
+		if t, f.err = f.class.Create(0); f.err == nil {
+			p.DecRef(t)
+		}
+	})
+	if f.err != nil {
+		return f.err
+	}
+
+	name := p.StrToPtr("meta_path")
+	defer p.FreeString(name)
+	metaPath := PyObject(p.Invoke("PySys_GetObject", name))
+	if metaPath == 0 {
+		return fmt.Errorf("sys.meta_path is missing: %w", ErrRuntimeError)
+	}
+	n := int(p.Invoke("PyList_Size", uintptr(metaPath)))
+	for i := 0; i < n; i++ {
+		item := PyObject(p.Invoke("PyList_GetItem", uintptr(metaPath), uintptr(i)))
+		if v, ok := f.class.valueOf(item); ok && v.(*builtinFinder).lib == p {
+			return nil
+		}
+	}
+
+	finder, err := p.FromGo(&builtinFinder{lib: p})
+	if err != nil {
+		return err
+	}
+	defer p.DecRef(finder)
+	if int32(p.Invoke("PyList_Insert", uintptr(metaPath), 0, uintptr(finder))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// findSpec is find_spec(fullname, path=None, target=None).  The spec it returns for the
+// dotted modules of the inittab has BuiltinImporter load them, as it does the others.
+func (f *builtinFinder) findSpec(fullname string, _ ...PyObject) (PyObject, error) {
+	p := f.lib
+	if !strings.Contains(fullname, ".") || p.inittabModule(fullname) == nil {
+		return 0, nil
+	}
+
+	machinery := p.ImportModule("importlib.machinery")
+	if machinery == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(machinery)
+	loader := p.GetAttrString(machinery, "BuiltinImporter")
+	if loader == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(loader)
+	name := p.NewUnicode(fullname)
+	defer p.DecRef(name)
+	spec := p.CallMethod(machinery, "ModuleSpec", name, loader)
+	if spec == 0 {
+		return 0, p.FetchError()
+	}
+	origin := p.NewUnicode("built-in")
+	defer p.DecRef(origin)
+	if err := p.SetAttrString(spec, "origin", origin); err != nil {
+		p.DecRef(spec)
+		return 0, err
+	}
+	return spec, nil
+}
@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"fmt"
+)
+
+// PySignatureParameter describes a single parameter of a callable as reported by
+// inspect.signature.
+type PySignatureParameter struct {
+	Name string `json:"name"`
+	// Kind is the inspect.Parameter kind name, e.g. POSITIONAL_OR_KEYWORD or VAR_POSITIONAL
+	Kind string `json:"kind"`
+	// Default is the repr of the default value when HasDefault is set
+	Default    string `json:"default,omitempty"`
+	HasDefault bool   `json:"has_default"`
+	// Annotation is the formatted annotation, or empty if the parameter has none
+	Annotation string `json:"annotation,omitempty"`
+}
+
+// PySignature is the Go form of an inspect.Signature.
+type PySignature struct {
+	Parameters       []PySignatureParameter `json:"parameters"`
+	ReturnAnnotation string                 `json:"return_annotation,omitempty"`
+}
+
+// PyObjectInfo collects everything the introspection API knows about an object.
+type PyObjectInfo struct {
+	TypeName  string       `json:"type_name"`
+	MRO       []string     `json:"mro"`
+	Dir       []string     `json:"dir"`
+	Callable  bool         `json:"callable"`
+	Signature *PySignature `json:"signature,omitempty"`
+}
+
+// typeQualifiedName returns module.qualname for a type object.  Types from the
+// builtins module are reported by their bare name, the same way repr(type) does.
+func (p *PythonLib) typeQualifiedName(t PyObject) string {
+	qualname := p.GetAttrString(t, "__qualname__")
+	if qualname == 0 {
+		p.Invoke("PyErr_Clear")
+		return "<unknown>"
+	}
+	defer p.DecRef(qualname)
+	name := p.UnicodeToString(qualname)
+
+	module := p.GetAttrString(t, "__module__")
+	if module == 0 {
+		p.Invoke("PyErr_Clear")
+		return name
+	}
+	defer p.DecRef(module)
+	modname := p.UnicodeToString(module)
+	if modname == "" || modname == "builtins" {
+		return name
+	}
+	return modname + "." + name
+}
+
+// GetTypeName returns the fully qualified name of the type of obj, e.g. "collections.OrderedDict"
+// or "int".
+func (p *PythonLib) GetTypeName(obj PyObject) string {
+	if obj == 0 {
+		return "NULL"
+	}
+	t := PyObject(p.Invoke("PyObject_Type", uintptr(obj)))
+	defer p.DecRef(t)
+	return p.typeQualifiedName(t)
+}
+
+// GetMRO returns the fully qualified names of the method resolution order of the type of obj,
+// starting with the type itself and ending with object.
+func (p *PythonLib) GetMRO(obj PyObject) []string {
+	t := PyObject(p.Invoke("PyObject_Type", uintptr(obj)))
+	defer p.DecRef(t)
+
+	mro := p.GetAttrString(t, "__mro__")
+	if mro == 0 {
+		p.Invoke("PyErr_Clear")
+		return nil
+	}
+	defer p.DecRef(mro)
+
+	count := int(p.Invoke("PyTuple_Size", uintptr(mro)))
+	retv := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		// PyTuple_GetItem returns a borrowed reference
+		base := PyObject(p.Invoke("PyTuple_GetItem", uintptr(mro), uintptr(i)))
+		retv = append(retv, p.typeQualifiedName(base))
+	}
+	return retv
+}
+
+// Dir returns the result of dir(obj) as a slice of names.
+func (p *PythonLib) Dir(obj PyObject) ([]string, error) {
+	list := p.Invoke("PyObject_Dir", uintptr(obj))
+	if list == 0 {
+		err := p.FetchError()
+		return nil, fmt.Errorf("dir() failed for %s: %w", p.GetTypeName(obj), err)
+	}
+	defer p.Invoke("Py_DecRef", list)
+
+	count := int(p.Invoke("PyList_Size", list))
+	retv := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		// PyList_GetItem returns a borrowed reference
+		item := PyObject(p.Invoke("PyList_GetItem", list, uintptr(i)))
+		retv = append(retv, p.UnicodeToString(item))
+	}
+	return retv, nil
+}
+
+// IsCallable reports whether obj can be called.
+func (p *PythonLib) IsCallable(obj PyObject) bool {
+	// PyCallable_Check returns a C int, only the low 32 bits are meaningful
+	return int32(p.Invoke("PyCallable_Check", uintptr(obj))) != 0
+}
+
+// GetSignature returns the parameter list of a callable using inspect.signature.  Builtins
+// that do not provide a __text_signature__ have no signature and return an error.
+func (p *PythonLib) GetSignature(obj PyObject) (*PySignature, error) {
+	if !p.IsCallable(obj) {
+		return nil, fmt.Errorf("%s is not callable", p.GetTypeName(obj))
+	}
+
+	inspect := p.ImportModule("inspect")
+	if inspect == 0 {
+		return nil, fmt.Errorf("could not import inspect: %w", p.FetchError())
+	}
+	defer p.DecRef(inspect)
+
+	sig := p.CallMethod(inspect, "signature", obj)
+	if sig == 0 {
+		err := p.FetchError()
+		return nil, fmt.Errorf("no signature found for %s: %w", p.GetTypeName(obj), err)
+	}
+	defer p.DecRef(sig)
+
+	// inspect.Parameter.empty marks missing defaults and annotations
+	paramType := p.GetAttrString(inspect, "Parameter")
+	defer p.DecRef(paramType)
+	empty := p.GetAttrString(paramType, "empty")
+	defer p.DecRef(empty)
+
+	formatAnnotation := func(annotation PyObject) string {
+		if annotation == empty {
+			return ""
+		}
+		s := p.CallMethod(inspect, "formatannotation", annotation)
+		if s == 0 {
+			p.Invoke("PyErr_Clear")
+			return p.ObjectToRepr(annotation)
+		}
+		defer p.DecRef(s)
+		return p.UnicodeToString(s)
+	}
+
+	retv := &PySignature{}
+
+	ret := p.GetAttrString(sig, "return_annotation")
+	retv.ReturnAnnotation = formatAnnotation(ret)
+	p.DecRef(ret)
+
+	// sig.parameters is an ordered mapping of name -> inspect.Parameter
+	params := p.GetAttrString(sig, "parameters")
+	defer p.DecRef(params)
+	values := p.CallMethod(params, "values")
+	defer p.DecRef(values)
+	list := p.Invoke("PySequence_List", uintptr(values))
+	if list == 0 {
+		err := p.FetchError()
+		return nil, fmt.Errorf("could not read parameters of %s: %w", p.GetTypeName(obj), err)
+	}
+	defer p.Invoke("Py_DecRef", list)
+
+	count := int(p.Invoke("PyList_Size", list))
+	retv.Parameters = make([]PySignatureParameter, 0, count)
+	for i := 0; i < count; i++ {
+		param := PyObject(p.Invoke("PyList_GetItem", list, uintptr(i)))
+		sp := PySignatureParameter{}
+
+		name := p.GetAttrString(param, "name")
+		sp.Name = p.UnicodeToString(name)
+		p.DecRef(name)
+
+		kind := p.GetAttrString(param, "kind")
+		kindname := p.GetAttrString(kind, "name")
+		sp.Kind = p.UnicodeToString(kindname)
+		p.DecRef(kindname)
+		p.DecRef(kind)
+
+		def := p.GetAttrString(param, "default")
+		if def != empty {
+			sp.HasDefault = true
+			sp.Default = p.ObjectToRepr(def)
+		}
+		p.DecRef(def)
+
+		annotation := p.GetAttrString(param, "annotation")
+		sp.Annotation = formatAnnotation(annotation)
+		p.DecRef(annotation)
+
+		retv.Parameters = append(retv.Parameters, sp)
+	}
+
+	return retv, nil
+}
+
+// Inspect gathers the type name, MRO, dir() listing and, for callables, the signature
+// of obj.  A callable without an introspectable signature is reported with a nil Signature.
+func (p *PythonLib) Inspect(obj PyObject) (*PyObjectInfo, error) {
+	if obj == 0 {
+		return nil, fmt.Errorf("cannot inspect a NULL object")
+	}
+
+	names, err := p.Dir(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	retv := &PyObjectInfo{
+		TypeName: p.GetTypeName(obj),
+		MRO:      p.GetMRO(obj),
+		Dir:      names,
+		Callable: p.IsCallable(obj),
+	}
+	if retv.Callable {
+		retv.Signature, _ = p.GetSignature(obj)
+	}
+	return retv, nil
+}
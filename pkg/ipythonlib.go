@@ -2,7 +2,11 @@ package pkg
 
 import (
 	_ "embed"
+	"io/fs"
+	"math/big"
+	"reflect"
 	"runtime"
+	"time"
 	"unicode/utf16"
 	"unicode/utf8"
 	"unsafe"
@@ -15,11 +19,116 @@ type IPythonLib interface {
 	FreeBuffer(addr uintptr)
 	Init(string) error
 	GetPyNone() uintptr
+	RegisterFunc(fptr interface{}, name string) error
 
 	NewPyMethodDefArray(count int) PyMethodDefArray
 	NewPyModuleDef(name string, doc string, methods *PyMethodDefArray) PyModuleDef
 	StrToPtr(str string) uintptr
 	PtrToStr(ptr uintptr) string
+
+	IncRef(obj PyObject)
+	DecRef(obj PyObject)
+	GetAttrString(obj PyObject, name string) PyObject
+	SetAttrString(obj PyObject, name string, value PyObject) error
+	UnicodeToString(obj PyObject) string
+	ObjectToString(obj PyObject) string
+	ObjectToRepr(obj PyObject) string
+	ImportModule(name string) PyObject
+	CallObject(fn PyObject, args ...PyObject) PyObject
+	CallMethod(obj PyObject, name string, args ...PyObject) PyObject
+
+	GetTypeName(obj PyObject) string
+	GetMRO(obj PyObject) []string
+	Dir(obj PyObject) ([]string, error)
+	IsCallable(obj PyObject) bool
+	GetSignature(obj PyObject) (*PySignature, error)
+	Inspect(obj PyObject) (*PyObjectInfo, error)
+
+	HasFunction(name string) bool
+	ErrorOccurred() bool
+	FetchError() error
+	NewPyError(exc PyObject) *PyError
+
+	NewUnicode(s string) PyObject
+	GetPyExc(name string) PyObject
+	NewException(module PyObject, name string, base PyObject, doc string) (PyObject, error)
+	RegisterError(target error, exc PyObject)
+	SetError(err error)
+	SetErrorString(excName string, format string, a ...any)
+	RaiseError(err error) uintptr
+
+	NewCallback(fn PyCFunction) uintptr
+	NewCallbackWithKeywords(fn PyCFunctionWithKeywords) uintptr
+	NewFunction(name string, flags int, fn PyCFunction) (PyObject, error)
+	NewFunctionWithKeywords(name string, fn PyCFunctionWithKeywords) (PyObject, error)
+	NewFunctionFastWithKeywords(name string, fn PyCFunctionFastWithKeywords) (PyObject, error)
+	NewCallable(fn func(args ...PyObject) (PyObject, error)) (PyObject, error)
+	NewCapsule(name string, v any) (PyObject, error)
+	CapsuleValue(obj PyObject, name string) (any, error)
+	MountFS(prefix string, fsys fs.FS) error
+	WithGIL(fn func() error) error
+	AllowThreads(fn func())
+	NewEventLoop() (*EventLoop, error)
+	NewGoEventLoop() (PyObject, error)
+	SetGoEventLoopPolicy() error
+
+	IsNone(obj PyObject) bool
+	NewNone() PyObject
+	NewFloat(v float64) PyObject
+	NewBytes(b []byte) PyObject
+	AsInt64(obj PyObject) (int64, error)
+	AsUint64(obj PyObject) (uint64, error)
+	AsFloat64(obj PyObject) (float64, error)
+	AsString(obj PyObject) (string, error)
+	AsBool(obj PyObject) (bool, error)
+	AsBytes(obj PyObject) ([]byte, error)
+	FromGo(v any) (PyObject, error)
+	ToGo(obj PyObject, target any) error
+	Iterate(obj PyObject) *PyIter
+
+	GetBuffer(obj PyObject, flags int) (*PyBuffer, error)
+	WithBuffer(obj PyObject, flags int, fn func(b *PyBuffer) error) error
+	NewTypeFromSpec(name string, basicsize int, itemsize int, flags uintptr, slots []PyType_Slot, bases PyObject) (PyObject, error)
+	NewTypeFromModuleAndSpec(module PyObject, name string, basicsize int, itemsize int, flags uintptr, slots []PyType_Slot, bases PyObject) (PyObject, error)
+	GetTypeSlot(t PyObject, slot int) uintptr
+	FreeHeapObject(self PyObject)
+	PyObjectHeadSize() int
+	NewMemoryView(data []byte, writable bool) (PyObject, error)
+	ExportMemory(data any, writable bool) (PyObject, error)
+	NewDict() (*Dict, error)
+	AsDict(obj PyObject) (*Dict, error)
+	NewList(values ...any) (*List, error)
+	AsList(obj PyObject) (*List, error)
+	NewTuple(values ...any) (*Tuple, error)
+	AsTuple(obj PyObject) (*Tuple, error)
+	NewSet(values ...any) (*Set, error)
+	AsSet(obj PyObject) (*Set, error)
+	NewListBuilder(capacity int) *ListBuilder
+	NewTupleBuilder(capacity int) *TupleBuilder
+	NewDictBuilder() *DictBuilder
+	NewSetBuilder() *SetBuilder
+	NewBigInt(x *big.Int) (PyObject, error)
+	AsBigInt(obj PyObject) (*big.Int, error)
+	NewDateTime(t time.Time) (PyObject, error)
+	AsTime(obj PyObject) (time.Time, error)
+	NewTimedelta(d time.Duration) (PyObject, error)
+	AsDuration(obj PyObject) (time.Duration, error)
+	NewCivilDate(d CivilDate) (PyObject, error)
+	AsCivilDate(obj PyObject) (CivilDate, error)
+	NewCivilTime(t CivilTime) (PyObject, error)
+	AsCivilTime(obj PyObject) (CivilTime, error)
+	NewCivilDateTime(dt CivilDateTime) (PyObject, error)
+	AsCivilDateTime(obj PyObject) (CivilDateTime, error)
+	InvokeStruct(name string, ret any, args ...any) error
+	NewComplex(c complex128) (PyObject, error)
+	AsComplex128(obj PyObject) (complex128, error)
+	FromJSON(data []byte) (PyObject, error)
+	FromJSONValue(v any) (PyObject, error)
+	ToJSON(obj PyObject) ([]byte, error)
+	ToJSONValue(obj PyObject, target any) error
+	NewModule(name string) *ModuleBuilder
+	ModuleState(module PyObject) (any, error)
+	NewClass(name string, constructor any, argNames ...string) *ClassBuilder
 }
 
 type PyFunctionParameter struct {
@@ -63,6 +172,20 @@ type PyStructs struct {
 	PyMethodDef      PyConfig `json:"PyMethodDef"`
 	PyModuleDef_Base PyConfig `json:"PyModuleDef_Base"`
 	PyModuleDef      PyConfig `json:"PyModuleDef"`
+	Py_complex       PyConfig `json:"Py_complex"`
+	PyGetSetDef      PyConfig `json:"PyGetSetDef"`
+}
+
+// Layout returns the layout of the named C struct, if the ctags have it.
+func (s *PyStructs) Layout(name string) (*PyConfig, bool) {
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("json") == name {
+			layout := v.Field(i).Addr().Interface().(*PyConfig)
+			return layout, layout.Size > 0
+		}
+	}
+	return nil, false
 }
 
 type PyCtags struct {
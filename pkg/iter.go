@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"iter"
+	"unsafe"
+)
+
+// PyIter iterates over a Python iterable from Go with range-over-func.  Iteration ends
+// quietly when the iterator is exhausted; any other exception raised while iterating ends
+// the loop and is reported by Err.
+//
+//	it := lib.Iterate(generator)
+//	for item := range it.All() {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type PyIter struct {
+	lib *PythonLib
+	obj PyObject
+	err error
+}
+
+// Iterate returns a PyIter over obj.  The reference to obj is borrowed and must stay valid
+// while iterating.
+func (p *PythonLib) Iterate(obj PyObject) *PyIter {
+	return &PyIter{lib: p, obj: obj}
+}
+
+// Err returns the error that ended the last iteration, or nil if it ran to completion or
+// was stopped by the loop body.
+func (it *PyIter) Err() error {
+	return it.err
+}
+
+// All yields the items of the iterable.  Each item is a borrowed reference that is released
+// when the loop body returns, including on break; IncRef an item to keep it.
+func (it *PyIter) All() iter.Seq[PyObject] {
+	p := it.lib
+	return func(yield func(PyObject) bool) {
+		it.err = nil
+		pyiter := p.Invoke("PyObject_GetIter", uintptr(it.obj))
+		if pyiter == 0 {
+			it.err = p.FetchError()
+			return
+		}
+		defer p.Invoke("Py_DecRef", pyiter)
+
+		for {
+			item := PyObject(p.Invoke("PyIter_Next", pyiter))
+			if item == 0 {
+				// PyIter_Next returns NULL without an exception once the iterator is exhausted,
+				// StopIteration is consumed for us
+				it.err = p.FetchError()
+				return
+			}
+			if !yieldItem(p, item, yield) {
+				return
+			}
+		}
+	}
+}
+
+// yieldItem passes item to yield and releases it afterwards, even if the loop body panics.
+func yieldItem(p *PythonLib, item PyObject, yield func(PyObject) bool) bool {
+	defer p.DecRef(item)
+	return yield(item)
+}
+
+// Items yields the key and value pairs of a mapping, as borrowed references that are released
+// when the loop body returns.  Dicts are walked in place with PyDict_Next, other mappings
+// through their items() method.
+func (it *PyIter) Items() iter.Seq2[PyObject, PyObject] {
+	p := it.lib
+	return func(yield func(PyObject, PyObject) bool) {
+		it.err = nil
+		if uintptr(p.typeOf(it.obj)) == p.PyData["PyDict_Type"] {
+			it.dictItems(yield)
+			return
+		}
+
+		items := p.CallMethod(it.obj, "items")
+		if items == 0 {
+			it.err = p.FetchError()
+			return
+		}
+		defer p.DecRef(items)
+
+		itemsIter := p.Iterate(items)
+		for pair := range itemsIter.All() {
+			if int(p.Invoke("PyTuple_Size", uintptr(pair))) != 2 {
+				p.Invoke("PyErr_Clear")
+				p.SetErrorString("TypeError", "items() must yield (key, value) pairs")
+				it.err = p.FetchError()
+				return
+			}
+			key := PyObject(p.Invoke("PyTuple_GetItem", uintptr(pair), 0))
+			value := PyObject(p.Invoke("PyTuple_GetItem", uintptr(pair), 1))
+			if !yield(key, value) {
+				return
+			}
+		}
+		it.err = itemsIter.Err()
+	}
+}
+
+// dictItems walks an exact dict with PyDict_Next.
+func (it *PyIter) dictItems(yield func(PyObject, PyObject) bool) {
+	p := it.lib
+
+	// pos, key and value out parameters for PyDict_Next
+	ptrsize := unsafe.Sizeof(uintptr(0))
+	out := p.Invoke("PyMem_Calloc", 3, ptrsize)
+	defer p.Invoke("PyMem_Free", out)
+	ppos, pkey, pvalue := out, out+ptrsize, out+2*ptrsize
+
+	for int32(p.Invoke("PyDict_Next", uintptr(it.obj), ppos, pkey, pvalue)) != 0 {
+		key := *(*PyObject)(unsafe.Pointer(pkey))
+		value := *(*PyObject)(unsafe.Pointer(pvalue))
+
+		// PyDict_Next hands out borrowed references, hold our own in case the loop body
+		// removes the entry
+		p.IncRef(key)
+		p.IncRef(value)
+		cont := func() bool {
+			defer p.DecRef(key)
+			defer p.DecRef(value)
+			return yield(key, value)
+		}()
+		if !cont {
+			return
+		}
+	}
+}
+
+// IterAs yields the items of it converted to T with ToGo.  A conversion error ends the
+// iteration and is reported by it.Err.
+func IterAs[T any](it *PyIter) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for item := range it.All() {
+			var v T
+			if err := it.lib.ToGo(item, &v); err != nil {
+				it.err = err
+				return
+			}
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// ItemsAs yields the key and value pairs of a mapping converted to K and V with ToGo.  A
+// conversion error ends the iteration and is reported by it.Err.
+func ItemsAs[K any, V any](it *PyIter) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for key, value := range it.Items() {
+			var k K
+			if err := it.lib.ToGo(key, &k); err != nil {
+				it.err = err
+				return
+			}
+			var v V
+			if err := it.lib.ToGo(value, &v); err != nil {
+				it.err = err
+				return
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,915 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// jsonFunctions are the addresses of the C API functions the JSON bridge calls for every
+// value.  They are called with purego.SyscallN, which costs less than half of Invoke, and
+// that call overhead is most of the time spent converting a document.  The functions passing
+// doubles are bound with RegisterFunc, see floatAPI.
+type jsonFunctions struct {
+	incRef                    uintptr
+	decRef                    uintptr
+	longFromLongLong          uintptr
+	longAsLongLongAndOverflow uintptr
+	unicodeFromStringAndSize  uintptr
+	unicodeAsUTF8AndSize      uintptr
+	dictNew                   uintptr
+	dictSetItem               uintptr
+	dictNext                  uintptr
+	listNew                   uintptr
+	listSize                  uintptr
+	listGetItem               uintptr
+	listSetItem               uintptr
+	tupleSize                 uintptr
+	tupleGetItem              uintptr
+	typeGetFlags              uintptr
+
+	// the exact types with a direct JSON form, Py_True and Py_False
+	unicodeType uintptr
+	longType    uintptr
+	floatType   uintptr
+	boolType    uintptr
+	dictType    uintptr
+	listType    uintptr
+	tupleType   uintptr
+	trueObj     uintptr
+	falseObj    uintptr
+
+	floats *floatFunctions
+}
+
+// jsonAPI looks up the JSON bridge functions on first use.
+func (p *PythonLib) jsonAPI() (*jsonFunctions, error) {
+	p.jsonOnce.Do(func() {
+		f := &jsonFunctions{}
+		symbols := []struct {
+			addr *uintptr
+			name string
+		}{
+			{&f.incRef, "Py_IncRef"},
+			{&f.decRef, "Py_DecRef"},
+			{&f.longFromLongLong, "PyLong_FromLongLong"},
+			{&f.longAsLongLongAndOverflow, "PyLong_AsLongLongAndOverflow"},
+			{&f.unicodeFromStringAndSize, "PyUnicode_FromStringAndSize"},
+			{&f.unicodeAsUTF8AndSize, "PyUnicode_AsUTF8AndSize"},
+			{&f.dictNew, "PyDict_New"},
+			{&f.dictSetItem, "PyDict_SetItem"},
+			{&f.dictNext, "PyDict_Next"},
+			{&f.listNew, "PyList_New"},
+			{&f.listSize, "PyList_Size"},
+			{&f.listGetItem, "PyList_GetItem"},
+			{&f.listSetItem, "PyList_SetItem"},
+			{&f.tupleSize, "PyTuple_Size"},
+			{&f.tupleGetItem, "PyTuple_GetItem"},
+			{&f.typeGetFlags, "PyType_GetFlags"},
+			// Py_True is &_Py_TrueStruct, Py_False &_Py_FalseStruct
+			{&f.trueObj, "_Py_TrueStruct"},
+			{&f.falseObj, "_Py_FalseStruct"},
+		}
+		for _, s := range symbols {
+			addr, err := OpenSymbol(p.DLL, s.name)
+			if err != nil {
+				p.jsonErr = fmt.Errorf("could not find %s: %v: %w", s.name, err, ErrNotImplementedError)
+				return
+			}
+			*s.addr = addr
+		}
+		f.unicodeType = p.PyData["PyUnicode_Type"]
+		f.longType = p.PyData["PyLong_Type"]
+		f.floatType = p.PyData["PyFloat_Type"]
+		f.boolType = p.PyData["PyBool_Type"]
+		f.dictType = p.PyData["PyDict_Type"]
+		f.listType = p.PyData["PyList_Type"]
+		f.tupleType = p.PyData["PyTuple_Type"]
+		f.floats = p.floatAPI()
+		p.jsonFuncs = f
+	})
+	return p.jsonFuncs, p.jsonErr
+}
+
+// jsonCalls calls the bridge functions for a decoder or encoder.  The arguments are copied to
+// an array of its own: a variadic slice handed to SyscallN would be moved to the heap on
+// every call.
+type jsonCalls struct {
+	lib  *PythonLib
+	f    *jsonFunctions
+	args [4]uintptr
+}
+
+func (c *jsonCalls) call(fn uintptr, args ...uintptr) uintptr {
+	n := copy(c.args[:], args)
+	r, _, _ := purego.SyscallN(fn, c.args[:n]...)
+	return r
+}
+
+// incRef is Py_IncRef.
+func (c *jsonCalls) incRef(obj PyObject) {
+	c.call(c.f.incRef, uintptr(obj))
+}
+
+// decRef is Py_DecRef.
+func (c *jsonCalls) decRef(obj PyObject) {
+	c.call(c.f.decRef, uintptr(obj))
+}
+
+// FromJSON decodes a JSON document, such as a json.RawMessage, straight into Python objects
+// and returns a new reference to the result.  Objects become dicts with their keys in document
+// order, the last of any duplicate keys winning, arrays become lists, integers become ints of
+// any size, other numbers floats, and null None.  Trailing data after the document is an
+// error, and invalid UTF-8 in strings is replaced by U+FFFD as encoding/json does.
+//
+// FromJSON is a convenience, not a fast path: every string, number, container and item costs
+// a call into the library, and no exported function builds a dict from many items at once.
+// json.loads of NewBytes(data) decodes a large document about four times as fast, as
+// tests/jsonbench measures, and is the better choice when decoding time matters.
+func (p *PythonLib) FromJSON(data []byte) (PyObject, error) {
+	f, err := p.jsonAPI()
+	if err != nil {
+		return 0, err
+	}
+	d := &jsonDecoder{jsonCalls: jsonCalls{lib: p, f: f}, data: data, strs: make(map[string]PyObject)}
+	defer d.releaseShared()
+
+	obj, err := d.decode(0)
+	if err != nil {
+		return 0, err
+	}
+	if d.skipSpace(); d.pos < len(d.data) {
+		p.DecRef(obj)
+		return 0, d.syntaxError("after top-level value")
+	}
+	return obj, nil
+}
+
+// FromJSONValue converts any value encoding/json can marshal to Python objects, honoring json
+// struct tags and Marshaler implementations.  It is FromJSON of json.Marshal(v).
+func (p *PythonLib) FromJSONValue(v any) (PyObject, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return p.FromJSON(data)
+}
+
+// jsonMaxDepth is the nesting limit of a document, that of encoding/json
+const jsonMaxDepth = 10000
+
+// jsonSharedLen is the longest string value shared between its occurrences in a document
+const jsonSharedLen = 16
+
+// jsonDecoder builds Python objects while scanning a JSON document.
+type jsonDecoder struct {
+	jsonCalls
+	data []byte
+	pos  int
+	// the object keys and short strings created so far, shared between their occurrences as
+	// json.loads does for keys, and the small ints
+	strs map[string]PyObject
+	ints [jsonSmallInts]PyObject
+	// the items of the arrays being decoded, the innermost last
+	items []PyObject
+}
+
+// the ints from 0 up to jsonSmallInts are shared within a document
+const jsonSmallInts = 256
+
+func (d *jsonDecoder) releaseShared() {
+	for _, s := range d.strs {
+		d.call(d.f.decRef, uintptr(s))
+	}
+	for _, i := range d.ints {
+		if i != 0 {
+			d.call(d.f.decRef, uintptr(i))
+		}
+	}
+}
+
+// syntaxError reports the byte at pos, found in context.
+func (d *jsonDecoder) syntaxError(context string) error {
+	if d.pos >= len(d.data) {
+		return fmt.Errorf("invalid JSON: unexpected end of input: %w", ErrValueError)
+	}
+	return fmt.Errorf("invalid JSON: invalid character %q %s at offset %d: %w", d.data[d.pos], context, d.pos, ErrValueError)
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// next skips whitespace and returns the byte at pos, 0 at the end of the document.
+func (d *jsonDecoder) next() byte {
+	d.skipSpace()
+	if d.pos < len(d.data) {
+		return d.data[d.pos]
+	}
+	return 0
+}
+
+// literal consumes word if the document continues with it.
+func (d *jsonDecoder) literal(word string) bool {
+	if !bytes.HasPrefix(d.data[d.pos:], []byte(word)) {
+		return false
+	}
+	d.pos += len(word)
+	return true
+}
+
+// decode builds the next value of the document.
+func (d *jsonDecoder) decode(depth int) (PyObject, error) {
+	if depth > jsonMaxDepth {
+		return 0, fmt.Errorf("invalid JSON: exceeded max depth of %d: %w", jsonMaxDepth, ErrValueError)
+	}
+	p, f := d.lib, d.f
+	switch c := d.next(); {
+	case c == '{':
+		return d.decodeObject(depth + 1)
+	case c == '[':
+		return d.decodeArray(depth + 1)
+	case c == '"':
+		s, err := d.scanString()
+		if err != nil {
+			return 0, err
+		}
+		return d.str(s, len(s) <= jsonSharedLen)
+	case c == '-' || c >= '0' && c <= '9':
+		return d.decodeNumber()
+	case d.literal("true"):
+		d.incRef(PyObject(f.trueObj))
+		return PyObject(f.trueObj), nil
+	case d.literal("false"):
+		d.incRef(PyObject(f.falseObj))
+		return PyObject(f.falseObj), nil
+	case d.literal("null"):
+		d.incRef(PyObject(p.PyNone))
+		return PyObject(p.PyNone), nil
+	}
+	return 0, d.syntaxError("looking for beginning of value")
+}
+
+func (d *jsonDecoder) decodeObject(depth int) (PyObject, error) {
+	p, f := d.lib, d.f
+	d.pos++
+	dict := PyObject(d.call(f.dictNew))
+	if dict == 0 {
+		return 0, p.FetchError()
+	}
+	fail := func(err error) (PyObject, error) {
+		p.DecRef(dict)
+		return 0, err
+	}
+	if d.next() == '}' {
+		d.pos++
+		return dict, nil
+	}
+	for {
+		if d.next() != '"' {
+			return fail(d.syntaxError("looking for beginning of object key string"))
+		}
+		s, err := d.scanString()
+		if err != nil {
+			return fail(err)
+		}
+		key, err := d.str(s, true)
+		if err != nil {
+			return fail(err)
+		}
+		// the key is held by the shared strings as well
+		d.decRef(key)
+		if d.next() != ':' {
+			return fail(d.syntaxError("after object key"))
+		}
+		d.pos++
+		value, err := d.decode(depth)
+		if err != nil {
+			return fail(err)
+		}
+		if int32(d.call(f.dictSetItem, uintptr(dict), uintptr(key), uintptr(value))) != 0 {
+			p.DecRef(value)
+			return fail(p.FetchError())
+		}
+		d.decRef(value)
+
+		switch d.next() {
+		case ',':
+			d.pos++
+		case '}':
+			d.pos++
+			return dict, nil
+		default:
+			return fail(d.syntaxError("after object key:value pair"))
+		}
+	}
+}
+
+func (d *jsonDecoder) decodeArray(depth int) (PyObject, error) {
+	p, f := d.lib, d.f
+	d.pos++
+	// the length isn't known up front, so the items are collected before the list is built
+	base := len(d.items)
+	fail := func(err error) (PyObject, error) {
+		for _, item := range d.items[base:] {
+			p.DecRef(item)
+		}
+		d.items = d.items[:base]
+		return 0, err
+	}
+	if d.next() == ']' {
+		d.pos++
+	} else {
+		for done := false; !done; {
+			item, err := d.decode(depth)
+			if err != nil {
+				return fail(err)
+			}
+			d.items = append(d.items, item)
+			switch d.next() {
+			case ',':
+				d.pos++
+			case ']':
+				d.pos++
+				done = true
+			default:
+				return fail(d.syntaxError("after array element"))
+			}
+		}
+	}
+
+	items := d.items[base:]
+	list := PyObject(d.call(f.listNew, uintptr(len(items))))
+	if list == 0 {
+		return fail(p.FetchError())
+	}
+	for i, item := range items {
+		// PyList_SetItem steals the reference to the item
+		d.call(f.listSetItem, uintptr(list), uintptr(i), uintptr(item))
+	}
+	d.items = d.items[:base]
+	return list, nil
+}
+
+// scanString consumes the string at pos and returns its text.  A string without escapes is a
+// slice of the document; the others are unquoted by encoding/json.
+func (d *jsonDecoder) scanString() ([]byte, error) {
+	start := d.pos + 1
+	ascii := true
+	for i := start; i < len(d.data); i++ {
+		switch c := d.data[i]; {
+		case c == '"':
+			d.pos = i + 1
+			if s := d.data[start:i]; ascii || utf8.Valid(s) {
+				return s, nil
+			}
+			return d.unquote(start-1, i+1)
+		case c == '\\':
+			return d.unquoteFrom(start - 1)
+		case c < 0x20:
+			d.pos = i
+			return nil, d.syntaxError("in string literal")
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	d.pos = len(d.data)
+	return nil, d.syntaxError("in string literal")
+}
+
+// unquoteFrom consumes the string with escapes starting at start.
+func (d *jsonDecoder) unquoteFrom(start int) ([]byte, error) {
+	for i := start + 1; i < len(d.data); i++ {
+		switch d.data[i] {
+		case '\\':
+			i++
+		case '"':
+			d.pos = i + 1
+			return d.unquote(start, i+1)
+		}
+	}
+	d.pos = len(d.data)
+	return nil, d.syntaxError("in string literal")
+}
+
+// unquote decodes the quoted string data[start:end] with encoding/json.
+func (d *jsonDecoder) unquote(start, end int) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(d.data[start:end], &s); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v at offset %d: %w", err, start, ErrValueError)
+	}
+	return unsafe.Slice(unsafe.StringData(s), len(s)), nil
+}
+
+// str returns a new reference to the str of s, shared between its occurrences if shared.
+func (d *jsonDecoder) str(s []byte, shared bool) (PyObject, error) {
+	if shared {
+		// the lookup doesn't copy s
+		if obj, ok := d.strs[string(s)]; ok {
+			d.incRef(obj)
+			return obj, nil
+		}
+	}
+	obj := PyObject(d.call(d.f.unicodeFromStringAndSize, uintptr(unsafe.Pointer(unsafe.SliceData(s))), uintptr(len(s))))
+	// s is only read during the call
+	runtime.KeepAlive(s)
+	if obj == 0 {
+		return 0, d.lib.FetchError()
+	}
+	if shared {
+		d.incRef(obj)
+		d.strs[string(s)] = obj
+	}
+	return obj, nil
+}
+
+// decodeNumber consumes a number.  It becomes an int if it is written as an integer, and a
+// float otherwise, as json.loads does.
+func (d *jsonDecoder) decodeNumber() (PyObject, error) {
+	p, f := d.lib, d.f
+	start := d.pos
+	digits := func() int {
+		n := 0
+		for d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9' {
+			d.pos++
+			n++
+		}
+		return n
+	}
+
+	if d.data[d.pos] == '-' {
+		d.pos++
+	}
+	intStart := d.pos
+	if n := digits(); n == 0 {
+		return 0, d.syntaxError("in numeric literal")
+	} else if n > 1 && d.data[intStart] == '0' {
+		d.pos = intStart + 1
+		return 0, d.syntaxError("after leading zero")
+	}
+	isFloat := false
+	if d.pos < len(d.data) && d.data[d.pos] == '.' {
+		isFloat = true
+		d.pos++
+		if digits() == 0 {
+			return 0, d.syntaxError("after decimal point in numeric literal")
+		}
+	}
+	if d.pos < len(d.data) && (d.data[d.pos] == 'e' || d.data[d.pos] == 'E') {
+		isFloat = true
+		d.pos++
+		if d.pos < len(d.data) && (d.data[d.pos] == '+' || d.data[d.pos] == '-') {
+			d.pos++
+		}
+		if digits() == 0 {
+			return 0, d.syntaxError("in exponent of numeric literal")
+		}
+	}
+	text := unsafe.String(&d.data[start], d.pos-start)
+
+	var retv PyObject
+	switch {
+	case isFloat:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("invalid JSON number %q: %w", text, ErrValueError)
+		}
+		// out of range numbers become inf or 0.0 like float() does
+		retv = PyObject(f.floats.fromDouble(v))
+	case len(text) <= 18:
+		// at most 18 digits always fit an int64
+		var v int64
+		for _, c := range []byte(text[intStart-start:]) {
+			v = v*10 + int64(c-'0')
+		}
+		if text[0] == '-' {
+			v = -v
+		}
+		return d.int(v)
+	default:
+		cs := p.StrToPtr(text)
+		defer p.FreeString(cs)
+		retv = PyObject(p.Invoke("PyLong_FromString", cs, 0, 10))
+	}
+	if retv == 0 {
+		return 0, p.FetchError()
+	}
+	return retv, nil
+}
+
+// int returns a new reference to the int v.
+func (d *jsonDecoder) int(v int64) (PyObject, error) {
+	small := v >= 0 && v < jsonSmallInts
+	if small && d.ints[v] != 0 {
+		d.incRef(d.ints[v])
+		return d.ints[v], nil
+	}
+	obj := PyObject(d.call(d.f.longFromLongLong, uintptr(v)))
+	if obj == 0 {
+		return 0, d.lib.FetchError()
+	}
+	if small {
+		d.incRef(obj)
+		d.ints[v] = obj
+	}
+	return obj, nil
+}
+
+// ToJSON encodes a Python object as JSON, walking it through the C API rather than json.dumps.
+// Dicts become objects with their keys in iteration order, lists and tuples arrays, ints of any
+// size integers and floats numbers that keep a fraction or exponent so they decode as floats
+// again.  Strings are written as UTF-8, escaping only what JSON requires.  As with json.dumps,
+// int, float, bool and None keys are converted to strings; other non-string keys, NaN and
+// infinities, circular references and types without a JSON form are errors wrapping
+// ErrTypeError or ErrValueError.
+//
+// Like FromJSON, ToJSON is a convenience: every value costs a call into the library, and
+// json.dumps encodes a large document about two and a half times as fast.
+func (p *PythonLib) ToJSON(obj PyObject) ([]byte, error) {
+	f, err := p.jsonAPI()
+	if err != nil {
+		return nil, err
+	}
+	out := p.Invoke("PyMem_Calloc", 1, unsafe.Sizeof(jsonOut{}))
+	if out == 0 {
+		return nil, fmt.Errorf("could not allocate the encoder state: %w", ErrMemoryError)
+	}
+	defer p.Invoke("PyMem_Free", out)
+
+	var buf bytes.Buffer
+	e := &jsonEncoder{
+		jsonCalls: jsonCalls{lib: p, f: f},
+		buf:       &buf,
+		out:       (*jsonOut)(unsafe.Pointer(out)),
+		active:    make(map[PyObject]bool),
+		keys:      make(map[PyObject]string),
+	}
+	defer e.releaseKeys()
+	if err := e.encode(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToJSONValue converts a Python object into the Go value pointed to by target through JSON,
+// with encoding/json semantics for the target.  It is json.Unmarshal of ToJSON(obj).
+func (p *PythonLib) ToJSONValue(obj PyObject, target any) error {
+	data, err := p.ToJSON(obj)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// jsonOut holds the out parameters of the functions the encoder calls, in C memory.
+type jsonOut struct {
+	// the overflow flag of PyLong_AsLongLongAndOverflow, or the size from
+	// PyUnicode_AsUTF8AndSize
+	n int
+	// the iteration state of PyDict_Next
+	pos   int
+	key   PyObject
+	value PyObject
+}
+
+// jsonEncoder writes Python objects as JSON.
+type jsonEncoder struct {
+	jsonCalls
+	buf *bytes.Buffer
+	out *jsonOut
+	// scratch space for formatting numbers
+	num [64]byte
+	// the containers being encoded, to detect circular references
+	active map[PyObject]bool
+	// the text of the object keys written so far, which repeat across the objects of a
+	// document; the keys are held so that their addresses aren't reused
+	keys map[PyObject]string
+}
+
+func (e *jsonEncoder) releaseKeys() {
+	for key := range e.keys {
+		e.call(e.f.decRef, uintptr(key))
+	}
+}
+
+func (e *jsonEncoder) encode(obj PyObject) error {
+	p, f := e.lib, e.f
+	if p.IsNone(obj) {
+		e.buf.WriteString("null")
+		return nil
+	}
+
+	// exact types first, which is nearly everything, then their subclasses
+	t := uintptr(p.typeOf(obj))
+	switch t {
+	case f.unicodeType:
+		return e.writeUnicode(obj)
+	case f.longType:
+		return e.writeInt(obj)
+	case f.floatType:
+		return e.writeFloat(obj)
+	case f.boolType:
+		e.writeBool(obj)
+		return nil
+	case f.dictType:
+		return e.container(obj, e.encodeDict)
+	case f.listType:
+		return e.container(obj, e.encodeList)
+	case f.tupleType:
+		return e.container(obj, e.encodeTuple)
+	}
+
+	flags := e.call(f.typeGetFlags, t)
+	switch {
+	case flags&Py_TPFLAGS_UNICODE_SUBCLASS != 0:
+		return e.writeUnicode(obj)
+	case flags&Py_TPFLAGS_LONG_SUBCLASS != 0:
+		return e.writeInt(obj)
+	case flags&Py_TPFLAGS_DICT_SUBCLASS != 0:
+		return e.container(obj, e.encodeMapping)
+	case flags&(Py_TPFLAGS_LIST_SUBCLASS|Py_TPFLAGS_TUPLE_SUBCLASS) != 0:
+		return e.container(obj, e.encodeIterable)
+	case p.isInstance(obj, f.floatType):
+		return e.writeFloat(obj)
+	}
+	return fmt.Errorf("object of type %s is not JSON serializable: %w", p.GetTypeName(obj), ErrTypeError)
+}
+
+// container encodes a dict or sequence, guarding against circular references.
+func (e *jsonEncoder) container(obj PyObject, encode func(PyObject) error) error {
+	if e.active[obj] {
+		return fmt.Errorf("circular reference detected: %w", ErrValueError)
+	}
+	e.active[obj] = true
+	// the items are borrowed, and Python code run by a subclass further down could drop the
+	// container's other references
+	e.incRef(obj)
+	defer func() {
+		e.decRef(obj)
+		delete(e.active, obj)
+	}()
+	return encode(obj)
+}
+
+func (e *jsonEncoder) encodeDict(obj PyObject) error {
+	f, out := e.f, e.out
+	e.buf.WriteByte('{')
+	pos := 0
+	for {
+		// the out parameters are shared with nested dicts, so the position is kept here
+		out.pos = pos
+		if int32(e.call(f.dictNext, uintptr(obj), uintptr(unsafe.Pointer(&out.pos)),
+			uintptr(unsafe.Pointer(&out.key)), uintptr(unsafe.Pointer(&out.value)))) == 0 {
+			break
+		}
+		key, value := out.key, out.value
+		if pos != 0 {
+			e.buf.WriteByte(',')
+		}
+		pos = out.pos
+		if err := e.encodeItem(key, value); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+// encodeMapping encodes a dict subclass through its items().
+func (e *jsonEncoder) encodeMapping(obj PyObject) error {
+	e.buf.WriteByte('{')
+	first := true
+	it := e.lib.Iterate(obj)
+	for key, value := range it.Items() {
+		if !first {
+			e.buf.WriteByte(',')
+		}
+		first = false
+		if err := e.encodeItem(key, value); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+func (e *jsonEncoder) encodeItem(key PyObject, value PyObject) error {
+	if err := e.encodeKey(key); err != nil {
+		return err
+	}
+	e.buf.WriteByte(':')
+	return e.encode(value)
+}
+
+// encodeKey writes an object key, converting the non-string keys json.dumps accepts.  Their
+// text never needs escaping.
+func (e *jsonEncoder) encodeKey(key PyObject) error {
+	p, f := e.lib, e.f
+	t := uintptr(p.typeOf(key))
+	if t == f.unicodeType {
+		if text, ok := e.keys[key]; ok {
+			e.buf.WriteString(text)
+			return nil
+		}
+		start := e.buf.Len()
+		if err := e.writeUnicode(key); err != nil {
+			return err
+		}
+		e.incRef(key)
+		e.keys[key] = string(e.buf.Bytes()[start:])
+		return nil
+	}
+	if e.call(f.typeGetFlags, t)&Py_TPFLAGS_UNICODE_SUBCLASS != 0 {
+		return e.writeUnicode(key)
+	}
+
+	e.buf.WriteByte('"')
+	var err error
+	switch {
+	case p.IsNone(key):
+		e.buf.WriteString("null")
+	case t == f.boolType:
+		e.writeBool(key)
+	case e.call(f.typeGetFlags, t)&Py_TPFLAGS_LONG_SUBCLASS != 0:
+		err = e.writeInt(key)
+	case p.isInstance(key, f.floatType):
+		err = e.writeFloat(key)
+	default:
+		return fmt.Errorf("keys must be str, int, float, bool or None, not %s: %w", p.GetTypeName(key), ErrTypeError)
+	}
+	if err != nil {
+		return err
+	}
+	e.buf.WriteByte('"')
+	return nil
+}
+
+func (e *jsonEncoder) encodeList(obj PyObject) error {
+	f := e.f
+	e.buf.WriteByte('[')
+	// the size and items are read again on every pass, in case a subclass further down
+	// changes the list
+	for i := 0; i < int(e.call(f.listSize, uintptr(obj))); i++ {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		item := PyObject(e.call(f.listGetItem, uintptr(obj), uintptr(i)))
+		if err := e.encode(item); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+	return nil
+}
+
+func (e *jsonEncoder) encodeTuple(obj PyObject) error {
+	f := e.f
+	e.buf.WriteByte('[')
+	n := int(e.call(f.tupleSize, uintptr(obj)))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		item := PyObject(e.call(f.tupleGetItem, uintptr(obj), uintptr(i)))
+		if err := e.encode(item); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+	return nil
+}
+
+// encodeIterable encodes a list or tuple subclass through its iterator.
+func (e *jsonEncoder) encodeIterable(obj PyObject) error {
+	e.buf.WriteByte('[')
+	first := true
+	it := e.lib.Iterate(obj)
+	for item := range it.All() {
+		if !first {
+			e.buf.WriteByte(',')
+		}
+		first = false
+		if err := e.encode(item); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	e.buf.WriteByte(']')
+	return nil
+}
+
+func (e *jsonEncoder) writeBool(obj PyObject) {
+	if uintptr(obj) == e.f.trueObj {
+		e.buf.WriteString("true")
+	} else {
+		e.buf.WriteString("false")
+	}
+}
+
+// writeInt formats an int of any size in decimal.
+func (e *jsonEncoder) writeInt(obj PyObject) error {
+	p, f := e.lib, e.f
+	v := int64(e.call(f.longAsLongLongAndOverflow, uintptr(obj), uintptr(unsafe.Pointer(&e.out.n))))
+	// the overflow flag is a C int
+	if *(*int32)(unsafe.Pointer(&e.out.n)) == 0 {
+		if v == -1 && p.ErrorOccurred() {
+			return p.FetchError()
+		}
+		e.buf.Write(strconv.AppendInt(e.num[:0], v, 10))
+		return nil
+	}
+	x, err := p.AsBigInt(obj)
+	if err != nil {
+		return err
+	}
+	e.buf.WriteString(x.String())
+	return nil
+}
+
+// writeFloat formats a float so that it decodes as a float again.
+func (e *jsonEncoder) writeFloat(obj PyObject) error {
+	p := e.lib
+	v := e.f.floats.asDouble(uintptr(obj))
+	if v == -1 && p.ErrorOccurred() {
+		return p.FetchError()
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("float %v is not JSON compliant: %w", v, ErrValueError)
+	}
+	b := strconv.AppendFloat(e.num[:0], v, 'g', -1, 64)
+	if bytes.IndexAny(b, ".eE") < 0 {
+		b = append(b, ".0"...)
+	}
+	e.buf.Write(b)
+	return nil
+}
+
+// writeUnicode writes a str, reading its UTF-8 form in place.
+func (e *jsonEncoder) writeUnicode(obj PyObject) error {
+	f := e.f
+	data := e.call(f.unicodeAsUTF8AndSize, uintptr(obj), uintptr(unsafe.Pointer(&e.out.n)))
+	if data == 0 {
+		// lone surrogates can't be encoded
+		return e.lib.FetchError()
+	}
+	e.writeString(unsafe.String((*byte)(unsafe.Pointer(data)), e.out.n))
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// writeString writes s as a JSON string, escaping quotes, backslashes and control characters.
+func (e *jsonEncoder) writeString(s string) {
+	b := e.buf
+	b.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		b.WriteString(s[start:i])
+		switch c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			b.WriteString(`\u00`)
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		}
+		start = i + 1
+	}
+	b.WriteString(s[start:])
+	b.WriteByte('"')
+}
@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// goMemory is the Go side of a GoMemory exporter object
+type goMemory struct {
+	pinner   runtime.Pinner
+	data     any
+	buf      uintptr
+	len      int
+	itemsize int
+	format   uintptr
+	readonly bool
+	// C memory holding the one dimensional shape and stride handed out in Py_buffer
+	layout uintptr
+}
+
+// goMemoryExporter owns the GoMemory type and the Go memory exported through it
+type goMemoryExporter struct {
+	once    sync.Once
+	err     error
+	typeobj PyObject
+	mu      sync.Mutex
+	exports map[PyObject]*goMemory
+	// struct module format strings in C memory, shared by all exports
+	formats map[string]uintptr
+}
+
+// NewMemoryView exposes data to Python as a memoryview without copying it.  The slice is
+// pinned with a runtime.Pinner until the memoryview, and every object derived from it, has
+// been collected by Python.  Go code must not resize data, and must not write to it while
+// Python may be reading it.  A read-only view raises on any attempt to write from Python.
+func (p *PythonLib) NewMemoryView(data []byte, writable bool) (PyObject, error) {
+	return p.ExportMemory(data, writable)
+}
+
+// MemoryViewOf exposes a typed slice to Python as a memoryview with a matching format, e.g.
+// "d" for []float64, so Python sees items rather than bytes.  See NewMemoryView for the
+// lifetime rules.
+func MemoryViewOf[T BufferElement](lib IPythonLib, data []T, writable bool) (PyObject, error) {
+	return lib.ExportMemory(data, writable)
+}
+
+// ExportMemory exposes a slice of any BufferElement type to Python as a memoryview.  It is the
+// untyped form of MemoryViewOf.
+func (p *PythonLib) ExportMemory(data any, writable bool) (PyObject, error) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("ExportMemory needs a slice, got %T: %w", data, ErrTypeError)
+	}
+	format, err := bufferFormatOf(v.Type().Elem())
+	if err != nil {
+		return 0, err
+	}
+
+	e := &p.memExporter
+	e.once.Do(func() { e.err = p.initMemoryExporter() })
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	m := &goMemory{
+		data:     data,
+		len:      v.Len() * int(v.Type().Elem().Size()),
+		itemsize: int(v.Type().Elem().Size()),
+		readonly: !writable,
+	}
+	if v.Len() > 0 {
+		first := v.Index(0).Addr().UnsafePointer()
+		m.pinner.Pin(first)
+		m.buf = uintptr(first)
+	}
+
+	e.mu.Lock()
+	m.format = e.formats[format]
+	if m.format == 0 {
+		m.format = p.StrToPtr(format)
+		e.formats[format] = m.format
+	}
+	e.mu.Unlock()
+
+	m.layout = p.Invoke("PyMem_Calloc", 2, unsafe.Sizeof(int(0)))
+	layout := unsafe.Slice((*int)(unsafe.Pointer(m.layout)), 2)
+	layout[0] = v.Len()
+	layout[1] = m.itemsize
+
+	exporter := PyObject(p.Invoke("PyType_GenericAlloc", uintptr(e.typeobj), 0))
+	if exporter == 0 {
+		p.Invoke("PyMem_Free", m.layout)
+		m.pinner.Unpin()
+		return 0, p.FetchError()
+	}
+	e.mu.Lock()
+	e.exports[exporter] = m
+	e.mu.Unlock()
+
+	// the memoryview keeps the exporter alive, and the exporter keeps the memory pinned
+	view := PyObject(p.Invoke("PyMemoryView_FromObject", uintptr(exporter)))
+	p.DecRef(exporter)
+	if view == 0 {
+		return 0, p.FetchError()
+	}
+	return view, nil
+}
+
+// initMemoryExporter creates the GoMemory type, whose instances export pinned Go memory
+// through the buffer protocol.
+func (p *PythonLib) initMemoryExporter() error {
+	e := &p.memExporter
+	e.exports = make(map[PyObject]*goMemory)
+	e.formats = make(map[string]uintptr)
+
+	getbuffer := func(exporter uintptr, view uintptr, flags uintptr) (retv uintptr) {
+		// a failed request must leave no reference to the exporter in the view, so a panic
+		// after PyBuffer_FillInfo drops the one it took
+		v := (*pyBufferStruct)(unsafe.Pointer(view))
+		v.obj = 0
+		defer func() {
+			if retv == callbackFailure && v.obj != 0 {
+				p.DecRef(PyObject(v.obj))
+				v.obj = 0
+			}
+		}()
+		defer p.recoverCallback(&retv, callbackFailure)
+
+		e.mu.Lock()
+		m := e.exports[PyObject(exporter)]
+		e.mu.Unlock()
+		if m == nil {
+			p.SetErrorString("BufferError", "GoMemory object is not attached to Go memory")
+			return callbackFailure
+		}
+
+		f := int(int32(flags))
+		var readonly uintptr
+		if m.readonly {
+			readonly = 1
+		}
+		// PyBuffer_FillInfo raises BufferError for writable requests on read-only memory and
+		// takes the reference to the exporter that the consumer releases
+		if int32(p.Invoke("PyBuffer_FillInfo", view, exporter, m.buf, uintptr(m.len), readonly, uintptr(f))) != 0 {
+			return callbackFailure
+		}
+		v.itemsize = m.itemsize
+		if f&PyBUF_FORMAT == PyBUF_FORMAT {
+			v.format = m.format
+		}
+		if f&PyBUF_ND == PyBUF_ND {
+			v.shape = m.layout
+		}
+		if f&PyBUF_STRIDES == PyBUF_STRIDES {
+			v.strides = m.layout + unsafe.Sizeof(int(0))
+		}
+		return 0
+	}
+
+	dealloc := func(self uintptr) {
+		var ignored uintptr
+		defer p.recoverCallback(&ignored, 0)
+
+		e.mu.Lock()
+		m := e.exports[PyObject(self)]
+		delete(e.exports, PyObject(self))
+		e.mu.Unlock()
+		if m != nil {
+			m.pinner.Unpin()
+			p.Invoke("PyMem_Free", m.layout)
+		}
+		p.FreeHeapObject(PyObject(self))
+	}
+
+	slots := []PyType_Slot{
+		{Slot: Py_bf_getbuffer, PFunc: purego.NewCallback(getbuffer)},
+		{Slot: Py_tp_dealloc, PFunc: purego.NewCallback(dealloc)},
+	}
+	t, err := p.NewTypeFromSpec("kindalib.GoMemory", p.PyObjectHeadSize(), 0, Py_TPFLAGS_DEFAULT|Py_TPFLAGS_DISALLOW_INSTANTIATION, slots, 0)
+	if err != nil {
+		return err
+	}
+	e.typeobj = t
+	return nil
+}
+
+// bufferFormatOf returns the native struct module format code for a BufferElement type.
+func bufferFormatOf(t reflect.Type) (string, error) {
+	var codes map[uintptr]string
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		codes = map[uintptr]string{1: "b", 2: "h", 4: "i", 8: "q"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		codes = map[uintptr]string{1: "B", 2: "H", 4: "I", 8: "Q"}
+	case reflect.Float32, reflect.Float64:
+		codes = map[uintptr]string{4: "f", 8: "d"}
+	}
+	if code, ok := codes[t.Size()]; ok {
+		return code, nil
+	}
+	return "", fmt.Errorf("cannot export %s memory to Python: %w", t, ErrTypeError)
+}
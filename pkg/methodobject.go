@@ -66,3 +66,10 @@ func (p PyMethodDefArray) SetMethodDef(index int, name string, meth uintptr, fla
 	docoffset := p.PyConfig.GetMemberOffset("ml_doc")
 	*(*uintptr)(unsafe.Pointer(&p.Buffer[indexoffset+docoffset])) = 0
 }
+
+// SetMethodDoc sets the docstring of the method at index, which SetMethodDef leaves NULL.
+func (p PyMethodDefArray) SetMethodDoc(index int, doc string) {
+	indexoffset := index * p.PyConfig.Size
+	docoffset := p.PyConfig.GetMemberOffset("ml_doc")
+	*(*uintptr)(unsafe.Pointer(&p.Buffer[indexoffset+docoffset])) = p.PythonLib.StrToPtr(doc)
+}
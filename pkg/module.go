@@ -0,0 +1,413 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ModuleBuilder declares a Python module made of Go functions and constants, replacing the
+// method table, callback and module definition boilerplate.  The builder methods return the
+// builder for chaining and remember the first error, which Register returns.
+//
+// The module uses multi-phase initialization (PEP 489): each module object created from the
+// definition runs the Py_mod_exec steps and gets its own state, so it behaves correctly when
+// it is created again, as on reload or in a subinterpreter.
+//
+//	mod, err := lib.NewModule("example").
+//		Doc("Example module").
+//		Func("add", func(a, b int) (int, error) { return a + b, nil }).
+//		Const("VERSION", "1.0").
+//		Register()
+type ModuleBuilder struct {
+	lib     *PythonLib
+	name    string
+	doc     string
+	funcs   []moduleFunc
+	consts  []moduleConst
+	classes []*ClassBuilder
+	exec    []func(module PyObject) error
+	state   func() any
+	free    func(state any)
+	err     error
+
+	// the definition, built on first use
+	def *moduleDefinition
+	// the NUL-terminated name of a module added with AppendInittab, and whether AppendInittab
+	// added it as the parent package of a submodule, which a later AppendInittab replaces
+	inittabName        []byte
+	inittabPlaceholder bool
+}
+
+type moduleFunc struct {
+	fn  *goFunc
+	doc string
+}
+
+type moduleConst struct {
+	name  string
+	value any
+}
+
+// NewModule starts the declaration of a module.
+func (p *PythonLib) NewModule(name string) *ModuleBuilder {
+	return &ModuleBuilder{lib: p, name: name}
+}
+
+// Doc sets the module docstring.
+func (b *ModuleBuilder) Doc(doc string) *ModuleBuilder {
+	b.doc = doc
+	return b
+}
+
+// Func adds a function to the module.  fn is any Go function: its arguments are converted
+// from Python with ToGo, and its result with FromGo.  It may return nothing, a value, an error,
+// or a value and an error, and a returned error is raised with SetError.  PyObject parameters
+// receive borrowed references, and a PyObject result must be a new reference.  Trailing
+// pointer parameters are optional, and a variadic parameter takes any remaining positional
+// arguments.  A leading context.Context parameter is not an argument, and fn wrapped in Async
+// returns an awaitable and runs in a goroutine.
+//
+// Naming the parameters in argNames lets Python pass them by keyword; otherwise they are
+// positional only.  The calling convention is METH_NOARGS for functions without parameters,
+// METH_O for a single required unnamed parameter, and METH_FASTCALL|METH_KEYWORDS otherwise,
+// so calls don't build an argument tuple.
+func (b *ModuleBuilder) Func(name string, fn any, argNames ...string) *ModuleBuilder {
+	return b.FuncDoc(name, "", fn, argNames...)
+}
+
+// FuncDoc adds a function with a docstring, see Func.
+func (b *ModuleBuilder) FuncDoc(name string, doc string, fn any, argNames ...string) *ModuleBuilder {
+	if b.err != nil {
+		return b
+	}
+	f, err := b.lib.newGoFunc(name, fn, argNames)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.funcs = append(b.funcs, moduleFunc{fn: f, doc: doc})
+	return b
+}
+
+// Const adds a module attribute holding value converted with FromGo.
+func (b *ModuleBuilder) Const(name string, value any) *ModuleBuilder {
+	b.consts = append(b.consts, moduleConst{name: name, value: value})
+	return b
+}
+
+// Class adds a class defined with NewClass.  Every module object gets its own type object,
+// created with the module by PyType_FromModuleAndSpec.
+func (b *ModuleBuilder) Class(c *ClassBuilder) *ModuleBuilder {
+	b.classes = append(b.classes, c)
+	return b
+}
+
+// State gives every module object created from the definition a Go state, the value
+// newState returns, which the module's functions reach with ModuleState.  newState runs
+// before the Exec steps.
+func (b *ModuleBuilder) State(newState func() any) *ModuleBuilder {
+	b.state = newState
+	return b
+}
+
+// Exec adds a Py_mod_exec step, run on every new module object after the constants are set.
+// Returning an error fails the import with it.
+func (b *ModuleBuilder) Exec(fn func(module PyObject) error) *ModuleBuilder {
+	b.exec = append(b.exec, fn)
+	return b
+}
+
+// Free sets a cleanup hook run when a module object is deallocated, with its state or nil for
+// modules without State.
+func (b *ModuleBuilder) Free(fn func(state any)) *ModuleBuilder {
+	b.free = fn
+	return b
+}
+
+// Register creates the module and adds it to sys.modules, so Python code can import it.  It
+// returns a new reference to the module.
+func (b *ModuleBuilder) Register() (PyObject, error) {
+	p := b.lib
+	module, err := b.create()
+	if err != nil {
+		return 0, err
+	}
+
+	modules := p.Invoke("PyImport_GetModuleDict")
+	name := p.StrToPtr(b.name)
+	defer p.FreeString(name)
+	if int32(p.Invoke("PyDict_SetItemString", modules, name, uintptr(module))) != 0 {
+		p.DecRef(module)
+		return 0, p.FetchError()
+	}
+	return module, nil
+}
+
+// create creates and executes a new module object from the definition.
+func (b *ModuleBuilder) create() (PyObject, error) {
+	p := b.lib
+	def, err := b.definition()
+	if err != nil {
+		return 0, err
+	}
+	spec, err := p.newModuleSpec(b.name)
+	if err != nil {
+		return 0, err
+	}
+	defer p.DecRef(spec)
+
+	module := PyObject(p.Invoke("PyModule_FromDefAndSpec2", def.def.GetBuffer(), uintptr(spec), PYTHON_API_VERSION))
+	if module == 0 {
+		return 0, p.FetchError()
+	}
+	// the import system sets __spec__ on the modules it creates
+	if err := p.SetAttrString(module, "__spec__", spec); err != nil {
+		p.DecRef(module)
+		return 0, err
+	}
+	if int32(p.Invoke("PyModule_ExecDef", uintptr(module), def.def.GetBuffer())) != 0 {
+		p.DecRef(module)
+		return 0, p.FetchError()
+	}
+	return module, nil
+}
+
+// newModuleSpec returns a new reference to a ModuleSpec for a module without a loader.
+func (p *PythonLib) newModuleSpec(name string) (PyObject, error) {
+	machinery := p.ImportModule("importlib.machinery")
+	if machinery == 0 {
+		return 0, p.FetchError()
+	}
+	defer p.DecRef(machinery)
+	pyname := p.NewUnicode(name)
+	defer p.DecRef(pyname)
+	spec := p.CallMethod(machinery, "ModuleSpec", pyname, PyObject(p.PyNone))
+	if spec == 0 {
+		return 0, p.FetchError()
+	}
+	return spec, nil
+}
+
+// moduleDefinition holds the memory of a module defined by a ModuleBuilder.  It is kept for
+// the lifetime of the PythonLib, as Python keeps pointers to it.
+type moduleDefinition struct {
+	methods PyMethodDefArray
+	def     PyModuleDef
+	slots   []PyModuleDefSlot
+
+	builder *ModuleBuilder
+}
+
+// moduleCallbacks are the exec and free callbacks of the definitions of every ModuleBuilder,
+// which find the builder from the definition of the module object they are called with.
+type moduleCallbacks struct {
+	mu sync.Mutex
+	// by exec step, setup first
+	exec []uintptr
+	free uintptr
+}
+
+// definition builds the multi-phase module definition on first use.
+func (b *ModuleBuilder) definition() (*moduleDefinition, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.def != nil {
+		return b.def, nil
+	}
+	p := b.lib
+
+	methods := p.NewPyMethodDefArray(len(b.funcs))
+	for i, f := range b.funcs {
+		methods.SetMethodDef(i, f.fn.name, p.methodTrampoline(i, f.fn.flags()), f.fn.flags())
+		methods.SetMethodDoc(i, f.fn.signatureDoc(f.fn.name, "$module", f.doc))
+	}
+	def := p.NewPyModuleDef(b.name, b.doc, &methods)
+
+	// the C state holds the handle of the Go state
+	if b.state != nil {
+		def.SetSize(int(unsafe.Sizeof(uintptr(0))))
+	} else {
+		def.SetSize(0)
+	}
+	var slots []PyModuleDefSlot
+	for i := 0; i <= len(b.exec); i++ {
+		slots = append(slots, PyModuleDefSlot{Slot: Py_mod_exec, Value: p.moduleExecCallback(i)})
+	}
+	slots = append(slots, PyModuleDefSlot{})
+	def.SetSlots(slots)
+	if b.state != nil || b.free != nil {
+		def.SetFree(p.moduleFreeCallback())
+	}
+
+	b.def = &moduleDefinition{methods: methods, def: def, slots: slots, builder: b}
+	p.moduleDefs = append(p.moduleDefs, b.def)
+	// PyModuleDef_Init readies the definition, and returns it as an object for PyInit
+	// functions to return
+	p.Invoke("PyModuleDef_Init", def.GetBuffer())
+	return b.def, nil
+}
+
+// moduleExecCallback returns the Py_mod_exec callback of exec step i, which is setup for
+// step 0 and the Exec hooks after it.
+func (p *PythonLib) moduleExecCallback(i int) uintptr {
+	m := &p.moduleCallbacks
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n := len(m.exec); n <= i; n++ {
+		step := n
+		cb := func(module uintptr) (retv uintptr) {
+			defer p.recoverCallback(&retv, callbackFailure)
+			b, err := p.moduleBuilderOf(PyObject(module))
+			if err == nil {
+				if step == 0 {
+					err = b.setup(PyObject(module))
+				} else {
+					err = b.exec[step-1](PyObject(module))
+				}
+			}
+			if err != nil {
+				p.SetError(err)
+				return callbackFailure
+			}
+			return 0
+		}
+		m.exec = append(m.exec, purego.NewCallback(cb))
+	}
+	return m.exec[i]
+}
+
+// moduleFreeCallback returns the m_free callback.
+func (p *PythonLib) moduleFreeCallback() uintptr {
+	m := &p.moduleCallbacks
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.free == 0 {
+		m.free = purego.NewCallback(p.freeModule)
+	}
+	return m.free
+}
+
+// moduleBuilderOf returns the ModuleBuilder that defined a module object.
+func (p *PythonLib) moduleBuilderOf(module PyObject) (*ModuleBuilder, error) {
+	def := p.Invoke("PyModule_GetDef", uintptr(module))
+	for _, d := range p.moduleDefs {
+		if d.def.GetBuffer() == def {
+			return d.builder, nil
+		}
+	}
+	if p.ErrorOccurred() {
+		return nil, p.FetchError()
+	}
+	return nil, fmt.Errorf("module was not defined by a ModuleBuilder: %w", ErrSystemError)
+}
+
+// setup is the first exec step of every module object: it creates the state, the constants
+// and the classes, and makes a package of a module of the inittab with submodules.
+func (b *ModuleBuilder) setup(module PyObject) error {
+	p := b.lib
+	if b.state != nil {
+		state := p.Invoke("PyModule_GetState", uintptr(module))
+		if state == 0 {
+			return p.FetchError()
+		}
+		*(*uintptr)(unsafe.Pointer(state)) = p.moduleStates.add(b.state())
+	}
+	if p.inittabModule(b.name) == b && p.hasInittabSubmodules(b.name) {
+		if err := p.setupInittabPackage(module); err != nil {
+			return err
+		}
+	}
+	for _, c := range b.consts {
+		value, err := p.FromGo(c.value)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", b.name, c.name, err)
+		}
+		err = p.SetAttrString(module, c.name, value)
+		p.DecRef(value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range b.classes {
+		t, err := c.Create(module)
+		if err != nil {
+			return err
+		}
+		err = p.SetAttrString(module, c.name, t)
+		p.DecRef(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeModule is the m_free of the definitions.  It runs the Free hook and drops the state.
+func (p *PythonLib) freeModule(module uintptr) {
+	var ignored uintptr
+	defer p.recoverCallback(&ignored, 0)
+
+	b, err := p.moduleBuilderOf(PyObject(module))
+	if err != nil {
+		// m_free has no way to report an error
+		p.Invoke("PyErr_Clear")
+		return
+	}
+	var state any
+	if b.state != nil {
+		// the state is NULL if the module was never executed
+		if cstate := p.Invoke("PyModule_GetState", module); cstate != 0 {
+			state = p.moduleStates.remove(*(*uintptr)(unsafe.Pointer(cstate)))
+		}
+	}
+	if b.free != nil {
+		b.free(state)
+	}
+}
+
+// ModuleState returns the Go state of a module object defined with a ModuleBuilder State.
+// Module functions reach their module by declaring a first parameter of type Self.
+//
+//	Func("count", func(self Self) int {
+//		st, _ := lib.ModuleState(PyObject(self))
+//		return st.(*counter).next()
+//	})
+func (p *PythonLib) ModuleState(module PyObject) (any, error) {
+	// the C state of other modules means something else
+	def := p.Invoke("PyModule_GetDef", uintptr(module))
+	if def == 0 {
+		if p.ErrorOccurred() {
+			return nil, p.FetchError()
+		}
+		return nil, fmt.Errorf("module has no definition: %w", ErrValueError)
+	}
+	if !p.hasGoState(def) {
+		return nil, fmt.Errorf("module was not defined with a ModuleBuilder State: %w", ErrValueError)
+	}
+	cstate := p.Invoke("PyModule_GetState", uintptr(module))
+	if cstate == 0 {
+		if p.ErrorOccurred() {
+			return nil, p.FetchError()
+		}
+		return nil, fmt.Errorf("module has no state: %w", ErrValueError)
+	}
+	state, ok := p.moduleStates.get(*(*uintptr)(unsafe.Pointer(cstate)))
+	if !ok {
+		return nil, fmt.Errorf("module has no Go state: %w", ErrValueError)
+	}
+	return state, nil
+}
+
+// hasGoState reports whether def is the definition of a ModuleBuilder with a State.
+func (p *PythonLib) hasGoState(def uintptr) bool {
+	for _, d := range p.moduleDefs {
+		if d.def.GetBuffer() == def {
+			return d.builder.state != nil
+		}
+	}
+	return false
+}
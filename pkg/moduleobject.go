@@ -4,6 +4,25 @@ import (
 	"unsafe"
 )
 
+// PYTHON_API_VERSION is the C API version PyModule_Create2 checks extension modules against.
+// It has been 1013 for every Python 3 release.
+const PYTHON_API_VERSION = 1013
+
+// Multi-phase initialization slot ids, from Include/moduleobject.h
+const (
+	Py_mod_create                = 1
+	Py_mod_exec                  = 2
+	Py_mod_multiple_interpreters = 3
+	Py_mod_gil                   = 4
+)
+
+// PyModuleDefSlot mirrors the C PyModuleDef_Slot struct.  An array of them ending with a zero
+// entry is the m_slots of a multi-phase module definition.
+type PyModuleDefSlot struct {
+	Slot  int32
+	Value uintptr
+}
+
 type PyModuleDef struct {
 	Buffer    []byte
 	PyConfig  *PyConfig
@@ -50,6 +69,10 @@ func (p *PythonLib) NewPyModuleDef(name string, doc string, methods *PyMethodDef
 	// get the struct offset for ob_base (PyObject)
 	pyobjectoffset := baseconf.GetMemberOffset("ob_base")
 	ob_refcntoffset := pyobjconf.GetMemberOffset("ob_refcnt")
+	if ob_refcntoffset < 0 {
+		// 3.12 wraps ob_refcnt in an anonymous union, which still starts the struct
+		ob_refcntoffset = 0
+	}
 	ob_typeoffset := pyobjconf.GetMemberOffset("ob_type")
 
 	// set the pyobject ob_refcnt to 1
@@ -82,3 +105,36 @@ func (p *PythonLib) NewPyModuleDef(name string, doc string, methods *PyMethodDef
 
 	return retv
 }
+
+// SetSize sets m_size, the number of bytes of per-module state PyModule_GetState returns.
+// NewPyModuleDef sets -1, which declares a module without state that can't be initialized
+// more than once.
+func (p PyModuleDef) SetSize(size int) {
+	sizeoffset := p.PyConfig.GetMemberOffset("m_size")
+	*(*int64)(unsafe.Pointer(&p.Buffer[sizeoffset])) = int64(size)
+}
+
+// SetSlots sets m_slots to a zero terminated array of PyModuleDefSlot, which makes the
+// definition multi-phase: it is passed to PyModuleDef_Init instead of PyModule_Create2.
+func (p PyModuleDef) SetSlots(slots []PyModuleDefSlot) {
+	slotsoffset := p.PyConfig.GetMemberOffset("m_slots")
+	*(*uintptr)(unsafe.Pointer(&p.Buffer[slotsoffset])) = uintptr(unsafe.Pointer(&slots[0]))
+}
+
+// SetTraverse sets m_traverse, the int (*)(PyObject *, visitproc, void *) callback visiting
+// the objects held by the module state.
+func (p PyModuleDef) SetTraverse(fn uintptr) {
+	*(*uintptr)(unsafe.Pointer(&p.Buffer[p.PyConfig.GetMemberOffset("m_traverse")])) = fn
+}
+
+// SetClear sets m_clear, the int (*)(PyObject *) callback dropping the objects held by the
+// module state.
+func (p PyModuleDef) SetClear(fn uintptr) {
+	*(*uintptr)(unsafe.Pointer(&p.Buffer[p.PyConfig.GetMemberOffset("m_clear")])) = fn
+}
+
+// SetFree sets m_free, the void (*)(void *) callback run with the module when it is
+// deallocated.
+func (p PyModuleDef) SetFree(fn uintptr) {
+	*(*uintptr)(unsafe.Pointer(&p.Buffer[p.PyConfig.GetMemberOffset("m_free")])) = fn
+}
@@ -45,6 +45,9 @@ const (
 	// Objects behave like an unbound method
 	Py_TPFLAGS_METHOD_DESCRIPTOR uintptr = 1 << 17
 
+	// Objects support type attribute cache
+	Py_TPFLAGS_HAVE_VERSION_TAG uintptr = 1 << 18
+
 	// Object has up-to-date type attribute cache
 	Py_TPFLAGS_VALID_VERSION_TAG uintptr = 1 << 19
 
@@ -65,8 +68,114 @@ const (
 	Py_TPFLAGS_DICT_SUBCLASS     uintptr = 1 << 29
 	Py_TPFLAGS_BASE_EXC_SUBCLASS uintptr = 1 << 30
 	Py_TPFLAGS_TYPE_SUBCLASS     uintptr = 1 << 31
+
+	// The flags every type starts with
+	Py_TPFLAGS_DEFAULT uintptr = Py_TPFLAGS_HAVE_VERSION_TAG
 )
 
-// func (p *PythonLib) GetTypeString(obj uintptr) string {
-// 	pcfg := p.CTags.PyStructs["PyObject"]
-// }
+// IncRef increments the reference count of obj.  NULL is ignored.
+func (p *PythonLib) IncRef(obj PyObject) {
+	p.Invoke("Py_IncRef", uintptr(obj))
+}
+
+// DecRef decrements the reference count of obj.  NULL is ignored.
+func (p *PythonLib) DecRef(obj PyObject) {
+	p.Invoke("Py_DecRef", uintptr(obj))
+}
+
+// GetAttrString returns a new reference to the attribute name of obj, or 0 with the
+// Python error indicator set if the attribute could not be retrieved.
+func (p *PythonLib) GetAttrString(obj PyObject, name string) PyObject {
+	n := p.StrToPtr(name)
+	defer p.FreeString(n)
+	return PyObject(p.Invoke("PyObject_GetAttrString", uintptr(obj), n))
+}
+
+// SetAttrString sets the attribute name of obj to value.  The value reference is borrowed.
+func (p *PythonLib) SetAttrString(obj PyObject, name string, value PyObject) error {
+	n := p.StrToPtr(name)
+	defer p.FreeString(n)
+	if int32(p.Invoke("PyObject_SetAttrString", uintptr(obj), n, uintptr(value))) != 0 {
+		return p.FetchError()
+	}
+	return nil
+}
+
+// UnicodeToString converts a Python str object to a Go string.  An empty string is
+// returned if obj is not a str.
+func (p *PythonLib) UnicodeToString(obj PyObject) string {
+	if obj == 0 {
+		return ""
+	}
+	s := p.Invoke("PyUnicode_AsUTF8", uintptr(obj))
+	if s == 0 {
+		p.Invoke("PyErr_Clear")
+		return ""
+	}
+	return p.PtrToStr(s)
+}
+
+// ObjectToString returns str(obj) as a Go string.
+func (p *PythonLib) ObjectToString(obj PyObject) string {
+	s := PyObject(p.Invoke("PyObject_Str", uintptr(obj)))
+	if s == 0 {
+		p.Invoke("PyErr_Clear")
+		return ""
+	}
+	defer p.DecRef(s)
+	return p.UnicodeToString(s)
+}
+
+// ObjectToRepr returns repr(obj) as a Go string.
+func (p *PythonLib) ObjectToRepr(obj PyObject) string {
+	s := PyObject(p.Invoke("PyObject_Repr", uintptr(obj)))
+	if s == 0 {
+		p.Invoke("PyErr_Clear")
+		return ""
+	}
+	defer p.DecRef(s)
+	return p.UnicodeToString(s)
+}
+
+// ImportModule imports the named module and returns a new reference to it, or 0 with
+// the Python error indicator set.
+func (p *PythonLib) ImportModule(name string) PyObject {
+	n := p.StrToPtr(name)
+	defer p.FreeString(n)
+	return PyObject(p.Invoke("PyImport_ImportModule", n))
+}
+
+// CallObject calls fn with the given positional arguments and returns a new reference
+// to the result, or 0 with the Python error indicator set.  The arguments are borrowed.
+func (p *PythonLib) CallObject(fn PyObject, args ...PyObject) PyObject {
+	tuple := p.Invoke("PyTuple_New", uintptr(len(args)))
+	if tuple == 0 {
+		return 0
+	}
+	defer p.Invoke("Py_DecRef", tuple)
+
+	for i, a := range args {
+		// PyTuple_SetItem steals a reference, so give it one of its own
+		p.IncRef(a)
+		p.Invoke("PyTuple_SetItem", tuple, uintptr(i), uintptr(a))
+	}
+	return PyObject(p.Invoke("PyObject_CallObject", uintptr(fn), tuple))
+}
+
+// CallMethod calls the method name of obj with the given positional arguments and
+// returns a new reference to the result, or 0 with the Python error indicator set.
+func (p *PythonLib) CallMethod(obj PyObject, name string, args ...PyObject) PyObject {
+	meth := p.GetAttrString(obj, name)
+	if meth == 0 {
+		return 0
+	}
+	defer p.DecRef(meth)
+	return p.CallObject(meth, args...)
+}
+
+// NewUnicode creates a new Python str from a Go string and returns a new reference to it.
+func (p *PythonLib) NewUnicode(s string) PyObject {
+	ptr := p.StrToPtr(s)
+	defer p.FreeString(ptr)
+	return PyObject(p.Invoke("PyUnicode_FromStringAndSize", ptr, uintptr(len(s))))
+}
@@ -0,0 +1,584 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// The protocol interfaces let the Go values of a class defined with NewClass behave like
+// native Python values.  The class gets the special methods of every interface its Go type
+// implements, wired into the type slots.
+//
+// Keys, items and operands are converted to their natural Go representation, as ToGo does
+// for an any, and instances of Go-backed classes to their Go value; objects without a Go
+// representation are passed as a borrowed PyObject.  Results are converted with FromGo, so a
+// method returning the class's own pointer type returns a new instance of the class.
+
+// Lenner implements __len__.
+type Lenner interface {
+	Len() int
+}
+
+// Getter implements __getitem__.  Returning an error wrapping ErrKeyError or ErrIndexError
+// raises the matching exception.
+type Getter interface {
+	GetItem(key any) (any, error)
+}
+
+// Setter implements __setitem__.
+type Setter interface {
+	SetItem(key any, value any) error
+}
+
+// Deleter implements __delitem__.
+type Deleter interface {
+	DelItem(key any) error
+}
+
+// Container implements the in operator, __contains__.
+type Container interface {
+	Contains(item any) (bool, error)
+}
+
+// Iterable implements __iter__.  An error yielded by the sequence is raised and ends the
+// iteration.
+type Iterable interface {
+	Iter() iter.Seq[any]
+}
+
+// Adder implements the + operator with the instance on the left, __add__.
+type Adder interface {
+	Add(other any) (any, error)
+}
+
+// ReflectedAdder implements the + operator with the instance on the right, __radd__.
+type ReflectedAdder interface {
+	RAdd(other any) (any, error)
+}
+
+// Subtracter implements the - operator, __sub__.
+type Subtracter interface {
+	Sub(other any) (any, error)
+}
+
+// ReflectedSubtracter implements __rsub__.
+type ReflectedSubtracter interface {
+	RSub(other any) (any, error)
+}
+
+// Multiplier implements the * operator, __mul__.
+type Multiplier interface {
+	Mul(other any) (any, error)
+}
+
+// ReflectedMultiplier implements __rmul__.
+type ReflectedMultiplier interface {
+	RMul(other any) (any, error)
+}
+
+// Divider implements the / operator, __truediv__.
+type Divider interface {
+	Div(other any) (any, error)
+}
+
+// ReflectedDivider implements __rtruediv__.
+type ReflectedDivider interface {
+	RDiv(other any) (any, error)
+}
+
+// MatMultiplier implements the @ operator, __matmul__.
+type MatMultiplier interface {
+	MatMul(other any) (any, error)
+}
+
+// ReflectedMatMultiplier implements __rmatmul__.
+type ReflectedMatMultiplier interface {
+	RMatMul(other any) (any, error)
+}
+
+// Negator implements unary -, __neg__.
+type Negator interface {
+	Neg() (any, error)
+}
+
+// Comparer implements the rich comparisons, returning a negative number, zero or a positive
+// number when the instance is less than, equal to or greater than other.
+type Comparer interface {
+	Compare(other any) (int, error)
+}
+
+// Equaler implements == and !=.  It takes precedence over Comparer for them.
+type Equaler interface {
+	Equal(other any) (bool, error)
+}
+
+// Hasher implements __hash__.  A class with a Comparer or Equaler but no Hasher is
+// unhashable, as in Python.
+type Hasher interface {
+	Hash() int64
+}
+
+// ContextManager implements the with statement.  __enter__ calls Enter and returns the
+// instance.  __exit__ calls Exit with the exception raised in the block as a *PyError, or
+// nil, and suppresses the exception when Exit returns true.
+type ContextManager interface {
+	Enter() error
+	Exit(err error) (bool, error)
+}
+
+// ErrNotImplemented is returned by an operator or comparison method for operands it doesn't
+// support.  The method returns NotImplemented to Python, which then tries the reflected
+// method of the other operand.
+var ErrNotImplemented = errors.New("not implemented")
+
+// binaryOperator wires a pair of operator interfaces into a number slot.
+type binaryOperator struct {
+	slot      int
+	name      string // the method name without underscores, as "add" for __add__ and __radd__
+	forward   reflect.Type
+	reflected reflect.Type
+	call      func(v any, other any) (any, error)
+	rcall     func(v any, other any) (any, error)
+}
+
+var binaryOperators = []binaryOperator{
+	{Py_nb_add, "add", reflect.TypeFor[Adder](), reflect.TypeFor[ReflectedAdder](),
+		func(v, other any) (any, error) { return v.(Adder).Add(other) },
+		func(v, other any) (any, error) { return v.(ReflectedAdder).RAdd(other) }},
+	{Py_nb_subtract, "sub", reflect.TypeFor[Subtracter](), reflect.TypeFor[ReflectedSubtracter](),
+		func(v, other any) (any, error) { return v.(Subtracter).Sub(other) },
+		func(v, other any) (any, error) { return v.(ReflectedSubtracter).RSub(other) }},
+	{Py_nb_multiply, "mul", reflect.TypeFor[Multiplier](), reflect.TypeFor[ReflectedMultiplier](),
+		func(v, other any) (any, error) { return v.(Multiplier).Mul(other) },
+		func(v, other any) (any, error) { return v.(ReflectedMultiplier).RMul(other) }},
+	{Py_nb_true_divide, "truediv", reflect.TypeFor[Divider](), reflect.TypeFor[ReflectedDivider](),
+		func(v, other any) (any, error) { return v.(Divider).Div(other) },
+		func(v, other any) (any, error) { return v.(ReflectedDivider).RDiv(other) }},
+	{Py_nb_matrix_multiply, "matmul", reflect.TypeFor[MatMultiplier](), reflect.TypeFor[ReflectedMatMultiplier](),
+		func(v, other any) (any, error) { return v.(MatMultiplier).MatMul(other) },
+		func(v, other any) (any, error) { return v.(ReflectedMatMultiplier).RMatMul(other) }},
+}
+
+// the comparison operators of tp_richcompare
+const (
+	Py_LT = 0
+	Py_LE = 1
+	Py_EQ = 2
+	Py_NE = 3
+	Py_GT = 4
+	Py_GE = 5
+)
+
+// implements reports whether the Go values of the class implement the interface I.
+func implements[I any](c *ClassBuilder) bool {
+	return c.goType.Implements(reflect.TypeFor[I]())
+}
+
+// protocolSlots returns the slots of the protocols the class's Go type implements.
+func (c *ClassBuilder) protocolSlots() []PyType_Slot {
+	var slots []PyType_Slot
+	p := c.lib
+	add := func(slot int, name string, cb any) {
+		slots = append(slots, PyType_Slot{Slot: slot, PFunc: p.classCallback(name, cb)})
+	}
+
+	if implements[Lenner](c) {
+		// both, so that len() and the PySequence functions agree
+		add(Py_mp_length, "mp_length", p.length)
+		add(Py_sq_length, "sq_length", p.length)
+	}
+	if implements[Getter](c) {
+		add(Py_mp_subscript, "mp_subscript", p.getItem)
+	}
+	if implements[Setter](c) || implements[Deleter](c) {
+		add(Py_mp_ass_subscript, "mp_ass_subscript", p.setItem)
+	}
+	if implements[Container](c) {
+		add(Py_sq_contains, "sq_contains", p.contains)
+	}
+	if implements[Iterable](c) {
+		add(Py_tp_iter, "tp_iter", p.iter)
+	}
+	for _, op := range binaryOperators {
+		if c.goType.Implements(op.forward) || c.goType.Implements(op.reflected) {
+			add(op.slot, "nb_"+op.name, p.binaryOperator(op))
+		}
+	}
+	if implements[Negator](c) {
+		add(Py_nb_negative, "nb_negative", p.negative)
+	}
+	if implements[Comparer](c) || implements[Equaler](c) {
+		add(Py_tp_richcompare, "tp_richcompare", p.richCompare)
+	}
+	if implements[Hasher](c) {
+		add(Py_tp_hash, "tp_hash", p.hash)
+	}
+	return slots
+}
+
+// protocolMethods returns the special methods that are not slots of heap types.
+func (c *ClassBuilder) protocolMethods() []protocolMethod {
+	if !implements[ContextManager](c) {
+		return nil
+	}
+	p := c.lib
+	enter := func(self PyObject, _ []PyObject) (PyObject, error) {
+		_, v, err := p.instanceValue(self)
+		if err != nil {
+			return 0, err
+		}
+		if err := v.Interface().(ContextManager).Enter(); err != nil {
+			return 0, err
+		}
+		p.IncRef(self)
+		return self, nil
+	}
+	exit := func(self PyObject, args []PyObject) (PyObject, error) {
+		_, v, err := p.instanceValue(self)
+		if err != nil {
+			return 0, err
+		}
+		if len(args) != 3 {
+			return 0, fmt.Errorf("__exit__() takes 3 arguments (%d given): %w", len(args), ErrTypeError)
+		}
+		var exc error
+		if value := args[1]; !p.IsNone(value) {
+			exc = p.NewPyError(value)
+		}
+		suppress, err := v.Interface().(ContextManager).Exit(exc)
+		if err != nil {
+			return 0, err
+		}
+		return p.FromGo(suppress)
+	}
+	return []protocolMethod{
+		{name: "__enter__", flags: METH_NOARGS, fn: enter},
+		{name: "__exit__", flags: METH_FASTCALL | METH_KEYWORDS, fn: exit},
+	}
+}
+
+// protocolMethod is a special method of the method table of a class.
+type protocolMethod struct {
+	name  string
+	flags int
+	fn    func(self PyObject, args []PyObject) (PyObject, error)
+}
+
+// call calls the method with the positional arguments, as the special methods take no
+// keyword arguments.
+func (m protocolMethod) call(self PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error) {
+	if kwnames != 0 {
+		return 0, fmt.Errorf("%s() takes no keyword arguments: %w", m.name, ErrTypeError)
+	}
+	return m.fn(self, args[:nargs])
+}
+
+// operand converts a key, item or operand for a protocol method.
+func (p *PythonLib) operand(obj PyObject) any {
+	if v, err := p.toInterface(obj); err == nil {
+		return v
+	}
+	return obj
+}
+
+// operatorResult converts the result of an operator or comparison, returning NotImplemented
+// for ErrNotImplemented.
+func (p *PythonLib) operatorResult(result any, err error) uintptr {
+	if errors.Is(err, ErrNotImplemented) {
+		return p.notImplemented()
+	}
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	return p.callbackResult(p.FromGo(result))
+}
+
+// notImplemented returns a new reference to NotImplemented.
+func (p *PythonLib) notImplemented() uintptr {
+	obj := p.PyData["_Py_NotImplementedStruct"]
+	p.IncRef(PyObject(obj))
+	return obj
+}
+
+// length is mp_length and sq_length.
+func (p *PythonLib) length(self uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, callbackFailure)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	n := v.Interface().(Lenner).Len()
+	if n < 0 {
+		p.SetError(fmt.Errorf("__len__() should return >= 0: %w", ErrValueError))
+		return callbackFailure
+	}
+	return uintptr(n)
+}
+
+// getItem is mp_subscript.
+func (p *PythonLib) getItem(self uintptr, key uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, 0)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	result, err := v.Interface().(Getter).GetItem(p.operand(PyObject(key)))
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	return p.callbackResult(p.FromGo(result))
+}
+
+// setItem is mp_ass_subscript, which deletes the item when value is NULL.
+func (p *PythonLib) setItem(self uintptr, key uintptr, value uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, callbackFailure)
+
+	c, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	if value == 0 {
+		if d, ok := v.Interface().(Deleter); ok {
+			err = d.DelItem(p.operand(PyObject(key)))
+		} else {
+			err = fmt.Errorf("'%s' object doesn't support item deletion: %w", c.name, ErrTypeError)
+		}
+	} else {
+		if s, ok := v.Interface().(Setter); ok {
+			err = s.SetItem(p.operand(PyObject(key)), p.operand(PyObject(value)))
+		} else {
+			err = fmt.Errorf("'%s' object does not support item assignment: %w", c.name, ErrTypeError)
+		}
+	}
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	return 0
+}
+
+// contains is sq_contains.
+func (p *PythonLib) contains(self uintptr, item uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, callbackFailure)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	ok, err := v.Interface().(Container).Contains(p.operand(PyObject(item)))
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// binaryOperator returns the number slot of op.  Python calls it with the instance as either
+// operand, and once for both when their types share the slot, as the types of all classes do:
+// it tries the forward method of the left operand, then the reflected method of the right
+// operand if it has another type.
+func (p *PythonLib) binaryOperator(op binaryOperator) func(a, b uintptr) uintptr {
+	return func(a uintptr, b uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+
+		left, right := PyObject(a), PyObject(b)
+		if c := p.instanceClass(left); c != nil && c.goType.Implements(op.forward) {
+			v, err := c.value(left)
+			if err != nil {
+				return p.RaiseError(err)
+			}
+			result, err := op.call(v.Interface(), p.operand(right))
+			if !errors.Is(err, ErrNotImplemented) || p.typeOf(left) == p.typeOf(right) {
+				return p.operatorResult(result, err)
+			}
+		}
+		if c := p.instanceClass(right); c != nil && c.goType.Implements(op.reflected) {
+			v, err := c.value(right)
+			if err != nil {
+				return p.RaiseError(err)
+			}
+			return p.operatorResult(op.rcall(v.Interface(), p.operand(left)))
+		}
+		return p.notImplemented()
+	}
+}
+
+// negative is nb_negative.
+func (p *PythonLib) negative(self uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, 0)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	return p.operatorResult(v.Interface().(Negator).Neg())
+}
+
+// richCompare is tp_richcompare.
+func (p *PythonLib) richCompare(self uintptr, other uintptr, op uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, 0)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	operand := p.operand(PyObject(other))
+	op = uintptr(int32(op))
+
+	if eq, ok := v.Interface().(Equaler); ok && (op == Py_EQ || op == Py_NE) {
+		equal, err := eq.Equal(operand)
+		return p.operatorResult(equal == (op == Py_EQ), err)
+	}
+	cmp, ok := v.Interface().(Comparer)
+	if !ok {
+		return p.notImplemented()
+	}
+	n, err := cmp.Compare(operand)
+	var result bool
+	switch op {
+	case Py_LT:
+		result = n < 0
+	case Py_LE:
+		result = n <= 0
+	case Py_EQ:
+		result = n == 0
+	case Py_NE:
+		result = n != 0
+	case Py_GT:
+		result = n > 0
+	case Py_GE:
+		result = n >= 0
+	}
+	return p.operatorResult(result, err)
+}
+
+// hash is tp_hash.
+func (p *PythonLib) hash(self uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, callbackFailure)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		p.SetError(err)
+		return callbackFailure
+	}
+	h := v.Interface().(Hasher).Hash()
+	if h == -1 {
+		// -1 is reserved for errors, as in Python
+		h = -2
+	}
+	return uintptr(h)
+}
+
+// iter is tp_iter.  It returns a GoIterator pulling from the Go sequence.
+func (p *PythonLib) iter(self uintptr) (retv uintptr) {
+	defer p.recoverCallback(&retv, 0)
+
+	_, v, err := p.instanceValue(PyObject(self))
+	if err != nil {
+		return p.RaiseError(err)
+	}
+	it, err := p.newGoIterator(v.Interface().(Iterable).Iter())
+	return p.callbackResult(it, err)
+}
+
+// goIterators owns the GoIterator type and the Go sequences its instances pull from
+type goIterators struct {
+	once    sync.Once
+	err     error
+	typeobj PyObject
+	pulls   handleTable
+}
+
+// goPull is the Go side of a GoIterator
+type goPull struct {
+	next func() (any, bool)
+	stop func()
+}
+
+// newGoIterator returns a new Python iterator over seq.
+func (p *PythonLib) newGoIterator(seq iter.Seq[any]) (PyObject, error) {
+	g := &p.goIterators
+	g.once.Do(func() { g.err = p.initGoIterators() })
+	if g.err != nil {
+		return 0, g.err
+	}
+	it := PyObject(p.Invoke("PyType_GenericAlloc", uintptr(g.typeobj), 0))
+	if it == 0 {
+		return 0, p.FetchError()
+	}
+	next, stop := iter.Pull(seq)
+	*p.pullHandleOf(it) = g.pulls.add(&goPull{next: next, stop: stop})
+	return it, nil
+}
+
+// pullHandleOf returns the location of the handle of a GoIterator's sequence.
+func (p *PythonLib) pullHandleOf(it PyObject) *uintptr {
+	return (*uintptr)(unsafe.Pointer(uintptr(it) + uintptr(p.PyObjectHeadSize())))
+}
+
+// initGoIterators creates the GoIterator type.
+func (p *PythonLib) initGoIterators() error {
+	g := &p.goIterators
+	selfIter, err := OpenSymbol(p.DLL, "PyObject_SelfIter")
+	if err != nil {
+		return err
+	}
+
+	iternext := func(self uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+
+		pull, ok := g.pulls.get(*p.pullHandleOf(PyObject(self)))
+		if !ok {
+			return 0
+		}
+		v, ok := pull.(*goPull).next()
+		if !ok {
+			// NULL without an exception ends the iteration
+			return 0
+		}
+		if err, ok := v.(error); ok {
+			pull.(*goPull).stop()
+			return p.RaiseError(err)
+		}
+		return p.callbackResult(p.FromGo(v))
+	}
+
+	dealloc := func(self uintptr) {
+		var ignored uintptr
+		defer p.recoverCallback(&ignored, 0)
+
+		handle := p.pullHandleOf(PyObject(self))
+		if pull := g.pulls.remove(*handle); pull != nil {
+			pull.(*goPull).stop()
+		}
+		*handle = 0
+		p.FreeHeapObject(PyObject(self))
+	}
+
+	slots := []PyType_Slot{
+		{Slot: Py_tp_iter, PFunc: selfIter},
+		{Slot: Py_tp_iternext, PFunc: purego.NewCallback(iternext)},
+		{Slot: Py_tp_dealloc, PFunc: purego.NewCallback(dealloc)},
+	}
+	basicsize := p.PyObjectHeadSize() + int(unsafe.Sizeof(uintptr(0)))
+	t, err := p.NewTypeFromSpec("kindalib.GoIterator", basicsize, 0, Py_TPFLAGS_DEFAULT|Py_TPFLAGS_DISALLOW_INSTANTIATION, slots, 0)
+	if err != nil {
+		return err
+	}
+	g.typeobj = t
+	return nil
+}
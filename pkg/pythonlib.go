@@ -7,6 +7,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sync"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -42,6 +44,78 @@ type PythonLib struct {
 	Free          InvokeFunc
 	PyData        map[string]uintptr
 	PyNone        uintptr
+
+	// PanicHandler, if set, is called with the recovered value and Go stack whenever a
+	// callback created by the library panics
+	PanicHandler func(value any, stack []byte)
+	// PanicException is the exception type raised for a recovered panic, SystemError if 0
+	PanicException PyObject
+
+	// DateTimeZoneInfo makes NewDateTime convert named Go locations to zoneinfo.ZoneInfo
+	// instead of fixed offset timezones
+	DateTimeZoneInfo bool
+
+	// Go errors mapped to Python exception types by RegisterError
+	errorTypes []registeredError
+
+	// C API functions bound with RegisterFunc on first use
+	floatOnce   sync.Once
+	floatFuncs  *floatFunctions
+	bigIntOnce  sync.Once
+	bigIntFuncs *bigIntFunctions
+
+	// the functions the JSON bridge calls for every value, looked up on first use
+	jsonOnce  sync.Once
+	jsonFuncs *jsonFunctions
+	jsonErr   error
+
+	// the datetime C API, imported on first use
+	dateTimeOnce sync.Once
+	dateTimeCAPI *pyDateTimeCAPI
+	dateTimeErr  error
+
+	// functions bound by InvokeStruct, by name and Go signature
+	structFuncsMu sync.Mutex
+	structFuncs   map[string]reflect.Value
+
+	// the GoMemory type and the Go memory exported through memoryviews
+	memExporter goMemoryExporter
+
+	// modules defined with NewModule, whose memory Python points into, the callbacks shared
+	// by their definitions, and the Go state of their module objects
+	moduleDefs      []*moduleDefinition
+	moduleCallbacks moduleCallbacks
+	moduleStates    handleTable
+
+	// classes defined with NewClass, the types created from them, the callbacks shared by
+	// those types, and the Go values of their instances
+	classDefs      []*ClassBuilder
+	classTypes     map[PyObject]*ClassBuilder
+	classCallbacks classCallbacks
+	classValues    handleTable
+
+	// the GoIterator type iterating over the Go sequences of Iterable classes
+	goIterators goIterators
+
+	// the shared callbacks of functions created with NewFunction, and their Go functions
+	trampolines trampolines
+
+	// the Go values held by capsules created with NewCapsule
+	capsules goCapsules
+
+	// the classes of the importers of MountFS
+	fsImporters fsImporters
+
+	// the modules added to the inittab with AppendInittab, and the finder of their
+	// submodules
+	inittab        []*ModuleBuilder
+	builtinFinders builtinFinders
+
+	// the function completing the futures of Async functions
+	asyncBridge asyncBridge
+
+	// the classes of the event loop of NewGoEventLoop
+	goLoops goLoops
 }
 
 func getFunction(functiondef PyFunction, dll uintptr) interface{} {
@@ -250,6 +324,16 @@ func NewPythonLibFromPaths(libpath string, pyhome string, pypkg string, version
 		}
 	}
 
+	// the built-in exception types are declared as "PyAPI_DATA(PyObject *) PyExc_KeyError;" and
+	// are not part of the ctags.  Note the PyData entry is the address of the PyObject* variable,
+	// use GetPyExc to get the exception type itself.
+	for _, name := range pyExcNames {
+		sym, err := OpenSymbol(dll, "PyExc_"+name)
+		if err == nil {
+			retv.PyData["PyExc_"+name] = sym
+		}
+	}
+
 	// py_none is a global static PyObject* that is used to return None from C functions
 	// it is available in the python library as "_Py_NoneStruct" and marked as:
 	// "PyAPI_DATA(PyObject) _Py_NoneStruct;"
@@ -259,6 +343,21 @@ func NewPythonLibFromPaths(libpath string, pyhome string, pypkg string, version
 	return retv, nil
 }
 
+// RegisterFunc binds fptr, a pointer to a Go function variable, to the named symbol of the
+// python library.  Use it for functions Invoke can't call, such as those taking or returning
+// floating point values, or private functions that are not loaded into the FTable.
+func (p *PythonLib) RegisterFunc(fptr interface{}, name string) error {
+	sym, err := OpenSymbol(p.DLL, name)
+	if err != nil {
+		return err
+	}
+	if sym == 0 {
+		return fmt.Errorf("symbol %s not found", name)
+	}
+	purego.RegisterFunc(fptr, sym)
+	return nil
+}
+
 func (p *PythonLib) GetFTableCount() int {
 	return len(p.FTable)
 }
@@ -0,0 +1,237 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// pyExcNames are the built-in exception types loaded into PyData as "PyExc_<name>"
+var pyExcNames = []string{
+	"BaseException", "BaseExceptionGroup", "Exception", "ExceptionGroup",
+	"ArithmeticError", "AssertionError", "AttributeError", "BlockingIOError",
+	"BrokenPipeError", "BufferError", "ChildProcessError", "ConnectionAbortedError",
+	"ConnectionError", "ConnectionRefusedError", "ConnectionResetError", "EOFError",
+	"FileExistsError", "FileNotFoundError", "FloatingPointError", "GeneratorExit",
+	"ImportError", "IndentationError", "IndexError", "InterruptedError",
+	"IsADirectoryError", "KeyError", "KeyboardInterrupt", "LookupError",
+	"MemoryError", "ModuleNotFoundError", "NameError", "NotADirectoryError",
+	"NotImplementedError", "OSError", "OverflowError", "PermissionError",
+	"ProcessLookupError", "RecursionError", "ReferenceError", "RuntimeError",
+	"StopAsyncIteration", "StopIteration", "SyntaxError", "SystemError",
+	"SystemExit", "TabError", "TimeoutError", "TypeError",
+	"UnboundLocalError", "UnicodeDecodeError", "UnicodeEncodeError", "UnicodeError",
+	"UnicodeTranslateError", "ValueError", "ZeroDivisionError",
+	"Warning", "BytesWarning", "DeprecationWarning", "EncodingWarning",
+	"FutureWarning", "ImportWarning", "PendingDeprecationWarning", "ResourceWarning",
+	"RuntimeWarning", "SyntaxWarning", "UnicodeWarning", "UserWarning",
+}
+
+// maxErrorChain bounds how many wrapped Go errors are converted into a __cause__ chain
+const maxErrorChain = 32
+
+type registeredError struct {
+	target error
+	exc    PyObject
+}
+
+// GetPyExc returns a borrowed reference to the built-in exception type with the given name,
+// e.g. "KeyError", or 0 if the library does not export it.
+func (p *PythonLib) GetPyExc(name string) PyObject {
+	sym, ok := p.PyData["PyExc_"+name]
+	if !ok {
+		return 0
+	}
+	// the symbol is a PyObject* variable holding the type
+	return *(*PyObject)(unsafe.Pointer(sym))
+}
+
+// NewException creates a new exception class with PyErr_NewExceptionWithDoc and adds it to
+// module under name.  A base of 0 derives from Exception.  The class is qualified with the
+// module name so tracebacks show e.g. "mymodule.MyError".  The returned reference is owned
+// by the caller.
+func (p *PythonLib) NewException(module PyObject, name string, base PyObject, doc string) (PyObject, error) {
+	modname := p.Invoke("PyModule_GetName", uintptr(module))
+	if modname == 0 {
+		return 0, p.FetchError()
+	}
+	qualified := p.PtrToStr(modname) + "." + name
+
+	n := p.StrToPtr(qualified)
+	defer p.FreeString(n)
+	var d uintptr
+	if doc != "" {
+		d = p.StrToPtr(doc)
+		defer p.FreeString(d)
+	}
+
+	exc := PyObject(p.Invoke("PyErr_NewExceptionWithDoc", n, d, uintptr(base), 0))
+	if exc == 0 {
+		return 0, p.FetchError()
+	}
+
+	// PyModule_AddObject steals a reference on success, keep one for the caller
+	p.IncRef(exc)
+	an := p.StrToPtr(name)
+	defer p.FreeString(an)
+	if int32(p.Invoke("PyModule_AddObject", uintptr(module), an, uintptr(exc))) != 0 {
+		p.DecRef(exc)
+		p.DecRef(exc)
+		return 0, p.FetchError()
+	}
+	return exc, nil
+}
+
+// RegisterError maps Go errors to a Python exception type.  When SetError is given an error
+// for which errors.Is(err, target) is true, the exception is raised with exc as its type.
+// Registrations are checked in order, so register more specific errors first.
+func (p *PythonLib) RegisterError(target error, exc PyObject) {
+	p.IncRef(exc)
+	p.errorTypes = append(p.errorTypes, registeredError{target: target, exc: exc})
+}
+
+// SetError sets the Python error indicator from a Go error so a callback can return NULL.
+// The exception type is picked from the errors registered with RegisterError, then from a
+// *PyError in the chain (which includes the Err* sentinels), and defaults to RuntimeError.
+// Errors wrapped by err are attached to the raised exception as its __cause__.
+func (p *PythonLib) SetError(err error) {
+	if err == nil {
+		return
+	}
+	exc := p.exceptionFromError(err, 0)
+	if exc == 0 {
+		return
+	}
+	t := p.Invoke("PyObject_Type", uintptr(exc))
+	p.Invoke("PyErr_SetObject", t, uintptr(exc))
+	p.Invoke("Py_DecRef", t)
+	p.DecRef(exc)
+}
+
+// RaiseError sets the Python error indicator from err and returns NULL, for use as the
+// return value of a Go callback.
+func (p *PythonLib) RaiseError(err error) uintptr {
+	p.SetError(err)
+	return 0
+}
+
+// exceptionFromError builds an exception instance for err, and recursively for the errors it
+// wraps, returning a new reference.
+func (p *PythonLib) exceptionFromError(err error, depth int) PyObject {
+	excType, owned := p.exceptionTypeFor(err)
+	if owned {
+		defer p.DecRef(excType)
+	}
+
+	msg := err.Error()
+	var pyerr *PyError
+	if errors.As(err, &pyerr) && pyerr == err && pyerr.Message != "" {
+		msg = pyerr.Message
+	}
+	next := unwrapFirst(err)
+	if next != nil && p.isErrorMarker(next) {
+		// the exception type stands for the marker, so it is not repeated in the message
+		msg = strings.TrimSuffix(msg, ": "+next.Error())
+	}
+
+	pymsg := p.NewUnicode(msg)
+	defer p.DecRef(pymsg)
+	exc := p.CallObject(excType, pymsg)
+	if exc == 0 {
+		return 0
+	}
+
+	if next != nil && depth < maxErrorChain && !p.isErrorMarker(next) {
+		cause := p.exceptionFromError(next, depth+1)
+		if cause != 0 {
+			// PyException_SetCause steals the reference to cause
+			p.Invoke("PyException_SetCause", uintptr(exc), uintptr(cause))
+		} else {
+			p.Invoke("PyErr_Clear")
+		}
+	}
+	return exc
+}
+
+// exceptionTypeFor picks the exception type to raise for err.  The returned bool reports
+// whether the caller owns a reference to the type.
+func (p *PythonLib) exceptionTypeFor(err error) (PyObject, bool) {
+	for _, r := range p.errorTypes {
+		if errors.Is(err, r.target) {
+			return r.exc, false
+		}
+	}
+
+	var pyerr *PyError
+	if errors.As(err, &pyerr) {
+		if t := p.lookupExceptionType(pyerr.Type); t != 0 {
+			return t, true
+		}
+	}
+
+	return p.GetPyExc("RuntimeError"), false
+}
+
+// lookupExceptionType resolves a qualified exception class name such as "KeyError" or
+// "json.decoder.JSONDecodeError" and returns a new reference to it, or 0 if it can't be found.
+func (p *PythonLib) lookupExceptionType(name string) PyObject {
+	if t := p.GetPyExc(name); t != 0 {
+		p.IncRef(t)
+		return t
+	}
+
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return 0
+	}
+	module := p.ImportModule(name[:i])
+	if module == 0 {
+		p.Invoke("PyErr_Clear")
+		return 0
+	}
+	defer p.DecRef(module)
+	t := p.GetAttrString(module, name[i+1:])
+	if t == 0 {
+		p.Invoke("PyErr_Clear")
+	}
+	return t
+}
+
+// isErrorMarker reports whether err only marks the exception type of the error wrapping it,
+// such as a registered target or a bare sentinel like ErrKeyError, and should not be
+// repeated as a __cause__.
+func (p *PythonLib) isErrorMarker(err error) bool {
+	for _, r := range p.errorTypes {
+		if err == r.target {
+			return true
+		}
+	}
+	pyerr, ok := err.(*PyError)
+	return ok && pyerr.Message == "" && pyerr.Traceback == nil
+}
+
+// unwrapFirst returns the first error wrapped by err, following both the single and multiple
+// error forms of Unwrap.
+func unwrapFirst(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Unwrap() []error }:
+		if errs := e.Unwrap(); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+	return nil
+}
+
+// SetErrorString raises the named built-in exception type with a message.
+func (p *PythonLib) SetErrorString(excName string, format string, a ...any) {
+	exc := p.GetPyExc(excName)
+	if exc == 0 {
+		exc = p.GetPyExc("RuntimeError")
+	}
+	msg := p.StrToPtr(fmt.Sprintf(format, a...))
+	defer p.FreeString(msg)
+	p.Invoke("PyErr_SetString", uintptr(exc), msg)
+}
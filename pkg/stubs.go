@@ -0,0 +1,516 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// WriteStubs writes a .pyi type stub for each module to dir, as dir/example.pyi for the
+// module "example", so mypy, pyright and editors can check and complete the Python code that
+// calls into Go.  Dotted names are laid out as packages: a module with submodules among
+// modules is written to __init__.pyi, and the parents missing from modules get an empty one.
+//
+// The annotations are derived from the Go signatures, as ToGo and FromGo convert them.  Go
+// structs become TypedDicts, and the Go values of the classes are annotated with the class,
+// when the module of the class is among modules, or as Any otherwise.  The stubs depend only
+// on the Go declarations, so a program can write them without initializing the interpreter,
+// as from go generate:
+//
+//	//go:generate go run . -pyi ./stubs
+func WriteStubs(dir string, modules ...*ModuleBuilder) error {
+	w := newStubWriter(modules)
+	packages := map[string]bool{}
+	for _, m := range modules {
+		parts := strings.Split(m.name, ".")
+		for i := 1; i < len(parts); i++ {
+			packages[strings.Join(parts[:i], ".")] = true
+		}
+	}
+
+	written := map[string]bool{}
+	for _, m := range modules {
+		stub, err := w.stub(m)
+		if err != nil {
+			return err
+		}
+		if err := writeStub(dir, m.name, packages[m.name], stub); err != nil {
+			return err
+		}
+		written[m.name] = true
+	}
+	for name := range packages {
+		if !written[name] {
+			if err := writeStub(dir, name, true, stubHeader(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stub returns the .pyi type stub of the module, see WriteStubs.
+func (b *ModuleBuilder) Stub() (string, error) {
+	return newStubWriter([]*ModuleBuilder{b}).stub(b)
+}
+
+func writeStub(dir string, name string, isPackage bool, stub string) error {
+	path := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(name, ".", "/")))
+	if isPackage {
+		path = filepath.Join(path, "__init__.pyi")
+	} else {
+		path += ".pyi"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(stub), 0o644)
+}
+
+func stubHeader(module string) string {
+	return fmt.Sprintf("# Code generated by kindalib from the Go declarations of %s. DO NOT EDIT.\n", module)
+}
+
+// stubWriter renders the stubs of a set of modules.  It knows the classes of all of them, so
+// the Go values of a class are annotated with it wherever they appear.
+type stubWriter struct {
+	classes map[reflect.Type]stubClass
+
+	// the state of the module being written
+	module    string
+	imports   map[string]bool
+	from      map[string]map[string]bool
+	dicts     []reflect.Type
+	dictNames map[reflect.Type]string
+	names     map[string]bool
+}
+
+type stubClass struct {
+	module string
+	name   string
+}
+
+// the annotations of the objects that Dict, List, Tuple and Set wrap
+var wrapperAnnotations = map[reflect.Type]string{
+	reflect.TypeFor[*Dict]():  "dict[Any, Any]",
+	reflect.TypeFor[*List]():  "list[Any]",
+	reflect.TypeFor[*Tuple](): "tuple[Any, ...]",
+	reflect.TypeFor[*Set]():   "set[Any]",
+}
+
+func newStubWriter(modules []*ModuleBuilder) *stubWriter {
+	w := &stubWriter{classes: map[reflect.Type]stubClass{}}
+	for _, m := range modules {
+		for _, c := range m.classes {
+			if c.goType != nil {
+				w.classes[c.goType] = stubClass{module: m.name, name: c.name}
+			}
+		}
+	}
+	return w
+}
+
+func (w *stubWriter) stub(b *ModuleBuilder) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	w.module = b.name
+	w.imports = map[string]bool{}
+	w.from = map[string]map[string]bool{}
+	w.dicts = nil
+	w.dictNames = map[reflect.Type]string{}
+	w.names = map[string]bool{}
+	for _, c := range b.classes {
+		if c.err != nil {
+			return "", c.err
+		}
+		w.names[c.name] = true
+	}
+
+	// the blocks of the stub, separated by blank lines
+	var blocks []string
+	if len(b.consts) != 0 {
+		var consts strings.Builder
+		for _, c := range b.consts {
+			fmt.Fprintf(&consts, "%s: %s\n", c.name, w.valueAnnotation(c.value))
+		}
+		blocks = append(blocks, consts.String())
+	}
+	for _, f := range b.funcs {
+		blocks = append(blocks, w.function("", f.fn, "", f.doc))
+	}
+	for _, c := range b.classes {
+		blocks = append(blocks, w.class(c))
+	}
+	// the TypedDicts come first, and declaring one may find the structs of its fields
+	var dicts []string
+	for i := 0; i < len(w.dicts); i++ {
+		dicts = append(dicts, w.typedDict(w.dicts[i]))
+	}
+	blocks = append(dicts, blocks...)
+
+	var out strings.Builder
+	out.WriteString(stubHeader(b.name))
+	if b.doc != "" {
+		out.WriteString("\n" + pyDocstring(b.doc, ""))
+	}
+	if imports := w.importBlock(); imports != "" {
+		out.WriteString("\n" + imports)
+	}
+	for _, block := range blocks {
+		out.WriteString("\n" + block)
+	}
+	return out.String(), nil
+}
+
+// importBlock returns the import statements of the names the module uses.
+func (w *stubWriter) importBlock() string {
+	var out strings.Builder
+	for _, m := range slices.Sorted(maps.Keys(w.imports)) {
+		fmt.Fprintf(&out, "import %s\n", m)
+	}
+	for _, m := range slices.Sorted(maps.Keys(w.from)) {
+		fmt.Fprintf(&out, "from %s import %s\n", m, strings.Join(slices.Sorted(maps.Keys(w.from[m])), ", "))
+	}
+	return out.String()
+}
+
+// importFrom returns name, imported from module.
+func (w *stubWriter) importFrom(module string, name string) string {
+	if w.from[module] == nil {
+		w.from[module] = map[string]bool{}
+	}
+	w.from[module][name] = true
+	return name
+}
+
+// qualified returns module.name, importing module.
+func (w *stubWriter) qualified(module string, name string) string {
+	w.imports[module] = true
+	return module + "." + name
+}
+
+// function renders a def statement for f, a method if self is not empty.
+func (w *stubWriter) function(indent string, f *goFunc, self string, doc string) string {
+	keyword := "def"
+	if f.async {
+		keyword = "async def"
+	}
+	def := fmt.Sprintf("%s%s %s%s -> %s:", indent, keyword, f.name, f.parameters(self, w.paramAnnotation), w.resultAnnotation(f))
+	if doc == "" {
+		return def + " ...\n"
+	}
+	return def + "\n" + pyDocstring(doc, indent+"    ")
+}
+
+func (w *stubWriter) class(c *ClassBuilder) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "class %s:\n", c.name)
+	if c.doc != "" {
+		out.WriteString(pyDocstring(c.doc, "    "))
+	}
+	fmt.Fprintf(&out, "    def __init__%s -> None: ...\n", c.init.parameters("self", w.paramAnnotation))
+	for _, m := range c.methods {
+		out.WriteString(w.function("    ", m.fn, "self", m.doc))
+	}
+	for _, prop := range c.props {
+		fmt.Fprintf(&out, "    @property\n    def %s(self) -> %s: ...\n", prop.name, w.resultAnnotation(prop.get))
+		if prop.set != nil {
+			fmt.Fprintf(&out, "    @%s.setter\n    def %s(self, value: %s) -> None: ...\n", prop.name, prop.name, w.annotation(prop.set.params[0], true))
+		}
+	}
+	if c.repr != nil {
+		out.WriteString("    def __repr__(self) -> str: ...\n")
+	}
+	for _, def := range w.protocolDefs(c) {
+		out.WriteString("    " + def + "\n")
+	}
+	return out.String()
+}
+
+// protocolDefs declares the special methods of the protocols the class implements.
+func (w *stubWriter) protocolDefs(c *ClassBuilder) []string {
+	var defs []string
+	if implements[Lenner](c) {
+		defs = append(defs, "def __len__(self) -> int: ...")
+	}
+	if implements[Getter](c) {
+		defs = append(defs, fmt.Sprintf("def __getitem__(self, key: %[1]s, /) -> %[1]s: ...", w.importFrom("typing", "Any")))
+	}
+	if implements[Setter](c) {
+		defs = append(defs, fmt.Sprintf("def __setitem__(self, key: %[1]s, value: %[1]s, /) -> None: ...", w.importFrom("typing", "Any")))
+	}
+	if implements[Deleter](c) {
+		defs = append(defs, fmt.Sprintf("def __delitem__(self, key: %s, /) -> None: ...", w.importFrom("typing", "Any")))
+	}
+	if implements[Container](c) {
+		defs = append(defs, "def __contains__(self, item: object, /) -> bool: ...")
+	}
+	if implements[Iterable](c) {
+		defs = append(defs, fmt.Sprintf("def __iter__(self) -> %s[%s]: ...", w.importFrom("typing", "Iterator"), w.importFrom("typing", "Any")))
+	}
+	for _, op := range binaryOperators {
+		if c.goType.Implements(op.forward) {
+			defs = append(defs, fmt.Sprintf("def __%s__(self, other: %[2]s, /) -> %[2]s: ...", op.name, w.importFrom("typing", "Any")))
+		}
+		if c.goType.Implements(op.reflected) {
+			defs = append(defs, fmt.Sprintf("def __r%s__(self, other: %[2]s, /) -> %[2]s: ...", op.name, w.importFrom("typing", "Any")))
+		}
+	}
+	if implements[Negator](c) {
+		defs = append(defs, fmt.Sprintf("def __neg__(self) -> %s: ...", w.importFrom("typing", "Any")))
+	}
+	if implements[Comparer](c) {
+		for _, op := range []string{"lt", "le", "gt", "ge"} {
+			defs = append(defs, fmt.Sprintf("def __%s__(self, other: %s, /) -> bool: ...", op, w.importFrom("typing", "Any")))
+		}
+	}
+	if implements[Comparer](c) || implements[Equaler](c) {
+		defs = append(defs,
+			"def __eq__(self, other: object, /) -> bool: ...",
+			"def __ne__(self, other: object, /) -> bool: ...")
+	}
+	switch {
+	case implements[Hasher](c):
+		defs = append(defs, "def __hash__(self) -> int: ...")
+	case implements[Comparer](c) || implements[Equaler](c):
+		defs = append(defs, fmt.Sprintf("__hash__: %s[None]  # type: ignore[assignment]", w.importFrom("typing", "ClassVar")))
+	}
+	if implements[ContextManager](c) {
+		defs = append(defs,
+			fmt.Sprintf("def __enter__(self) -> %s: ...", c.name),
+			fmt.Sprintf("def __exit__(self, exc_type: type[BaseException] | None, exc: BaseException | None, tb: %s | None, /) -> bool: ...", w.importFrom("types", "TracebackType")))
+	}
+	return defs
+}
+
+// typedDict declares the TypedDict of a struct, with the fields FromGo puts in its dict.
+func (w *stubWriter) typedDict(t reflect.Type) string {
+	name := w.dictNames[t]
+	typedDict := w.importFrom("typing", "TypedDict")
+	var names, annotations []string
+	identifiers := true
+	for i := 0; i < t.NumField(); i++ {
+		field, ok := pyFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		names = append(names, field)
+		annotations = append(annotations, w.annotation(t.Field(i).Type, false))
+		identifiers = identifiers && isPyIdentifier(field)
+	}
+
+	var out strings.Builder
+	if !identifiers {
+		// keys that are not identifiers need the functional syntax
+		fields := make([]string, len(names))
+		for i := range names {
+			fields[i] = fmt.Sprintf("%q: %s", names[i], annotations[i])
+		}
+		fmt.Fprintf(&out, "%s = %s(%q, {%s})\n", name, typedDict, name, strings.Join(fields, ", "))
+		return out.String()
+	}
+	fmt.Fprintf(&out, "class %s(%s):\n", name, typedDict)
+	if len(names) == 0 {
+		out.WriteString("    ...\n")
+	}
+	for i := range names {
+		fmt.Fprintf(&out, "    %s: %s\n", names[i], annotations[i])
+	}
+	return out.String()
+}
+
+// dictName returns the name of the TypedDict of a struct, declaring it on first use.
+func (w *stubWriter) dictName(t reflect.Type) string {
+	if name, ok := w.dictNames[t]; ok {
+		return name
+	}
+	name := t.Name()
+	for i := 2; w.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+	w.names[name] = true
+	w.dictNames[t] = name
+	w.dicts = append(w.dicts, t)
+	return name
+}
+
+func (w *stubWriter) paramAnnotation(t reflect.Type) string {
+	return w.annotation(t, true)
+}
+
+func (w *stubWriter) resultAnnotation(f *goFunc) string {
+	if !f.hasResult {
+		return "None"
+	}
+	return w.annotation(f.fn.Type().Out(0), false)
+}
+
+func (w *stubWriter) valueAnnotation(v any) string {
+	if v == nil {
+		return "None"
+	}
+	return w.annotation(reflect.TypeOf(v), false)
+}
+
+// annotation returns the annotation of a Go type, as ToGo converts parameters and FromGo
+// results.  Parameters of slice types take any iterable.
+func (w *stubWriter) annotation(t reflect.Type, param bool) string {
+	if c, ok := w.classes[t]; ok {
+		if c.module == w.module {
+			return c.name
+		}
+		return w.qualified(c.module, c.name)
+	}
+	if a, ok := wrapperAnnotations[t]; ok {
+		w.importFrom("typing", "Any")
+		return a
+	}
+	switch t {
+	case pyObjectType:
+		return w.importFrom("typing", "Any")
+	case bigIntType:
+		return "int"
+	case timeType, civilDateTimeType:
+		return w.qualified("datetime", "datetime")
+	case durationType:
+		return w.qualified("datetime", "timedelta")
+	case civilDateType:
+		return w.qualified("datetime", "date")
+	case civilTimeType:
+		return w.qualified("datetime", "time")
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Complex64, reflect.Complex128:
+		return "complex"
+	case reflect.String:
+		return "str"
+	case reflect.Pointer:
+		return optional(w.annotation(t.Elem(), param))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		fallthrough
+	case reflect.Array:
+		if param {
+			return fmt.Sprintf("%s[%s]", w.importFrom("typing", "Iterable"), w.annotation(t.Elem(), param))
+		}
+		return fmt.Sprintf("list[%s]", w.annotation(t.Elem(), param))
+	case reflect.Map:
+		return fmt.Sprintf("dict[%s, %s]", w.annotation(t.Key(), param), w.annotation(t.Elem(), param))
+	case reflect.Struct:
+		if t.Name() == "" {
+			return fmt.Sprintf("dict[str, %s]", w.importFrom("typing", "Any"))
+		}
+		return w.dictName(t)
+	}
+	return w.importFrom("typing", "Any")
+}
+
+// optional adds None to an annotation that doesn't allow it.
+func optional(annotation string) string {
+	if annotation == "Any" || annotation == "None" || strings.HasSuffix(annotation, " | None") {
+		return annotation
+	}
+	return annotation + " | None"
+}
+
+// parameters lists the parameters of f as in a def statement, starting with self if it is
+// not empty.  annotate gives the annotation of a parameter type, and without it the list is
+// a text signature.  Parameters without names in argNames are positional only, and the
+// optional ones default to None.
+func (f *goFunc) parameters(self string, annotate func(reflect.Type) string) string {
+	var params []string
+	if self != "" {
+		params = append(params, self)
+	}
+	for i, t := range f.params {
+		param := fmt.Sprintf("arg%d", i+1)
+		if len(f.argNames) != 0 {
+			param = f.argNames[i]
+		}
+		isOptional := i >= f.required
+		switch {
+		case annotate != nil && isOptional:
+			param += ": " + optional(annotate(t)) + " = None"
+		case annotate != nil:
+			param += ": " + annotate(t)
+		case isOptional:
+			param += "=None"
+		}
+		params = append(params, param)
+	}
+	if len(f.params) != 0 && len(f.argNames) == 0 {
+		params = append(params, "/")
+	}
+	if f.variadic != nil {
+		param := "*args"
+		if annotate != nil {
+			param += ": " + annotate(f.variadic)
+		}
+		params = append(params, param)
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+// signatureDoc prefixes doc with the text signature of f, from which Python sets the
+// __text_signature__ that inspect.signature and help() show.  self is "$module" or "$self"
+// for functions and methods, and empty for the constructor of a class named name.
+func (f *goFunc) signatureDoc(name string, self string, doc string) string {
+	return name + f.parameters(self, nil) + "\n--\n\n" + doc
+}
+
+// pyDocstring renders doc as a docstring indented by indent.
+func pyDocstring(doc string, indent string) string {
+	doc = strings.ReplaceAll(doc, `\`, `\\`)
+	doc = strings.ReplaceAll(doc, `"""`, `\"\"\"`)
+	lines := strings.Split(doc, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	if len(lines) > 1 {
+		lines = append(lines, indent)
+	}
+	return indent + `"""` + strings.Join(lines, "\n") + `"""` + "\n"
+}
+
+// pyKeywords can't be used as identifiers
+var pyKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true, "def": true,
+	"del": true, "elif": true, "else": true, "except": true, "finally": true, "for": true,
+	"from": true, "global": true, "if": true, "import": true, "in": true, "is": true,
+	"lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true, "raise": true,
+	"return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// isPyIdentifier reports whether s can be used as an ASCII Python identifier.
+func isPyIdentifier(s string) bool {
+	if s == "" || pyKeywords[s] {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
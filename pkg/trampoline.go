@@ -0,0 +1,289 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// trampolines dispatch the calls of every function created with NewFunction through one
+// native callback per calling convention.  purego supports a fixed number of callbacks and
+// never releases them, so a native callback per function would limit how many Go functions
+// a program can expose over its lifetime.
+//
+// The self of each function object is a capsule holding the handle of the Go function, and
+// the capsule's context the PyMethodDef the object points to.  When the function object is
+// deallocated, it releases the capsule, whose destructor drops the handle and frees the
+// method definition.
+//
+// The method tables of modules and classes have no capsule: their functions are bound to
+// the module or the instance.  Their entries use a callback per index instead, which finds
+// the table from that self, see methodTrampoline.
+type trampolines struct {
+	once sync.Once
+	err  error
+
+	varargs    uintptr
+	keywords   uintptr
+	fast       uintptr
+	destructor uintptr
+
+	// C API functions called on every dispatch
+	getPointer uintptr
+	getContext uintptr
+	// the name of the capsules, which PyCapsule_GetPointer checks
+	name uintptr
+
+	funcs handleTable
+
+	// the callbacks of the method tables of ModuleBuilder and ClassBuilder, by calling
+	// convention and index in the table
+	methodsMu sync.Mutex
+	methods   [3][]uintptr
+}
+
+// NewFunction returns a new reference to a builtin function object named name that calls
+// fn.  flags is METH_VARARGS, METH_O or METH_NOARGS, and fn receives the argument tuple, the
+// argument or 0 accordingly.  The self passed to fn is 0.
+//
+// Unlike NewCallback, NewFunction doesn't create a native callback: any number of functions
+// can be created, and each is released with the function object.
+func (p *PythonLib) NewFunction(name string, flags int, fn PyCFunction) (PyObject, error) {
+	switch flags {
+	case METH_VARARGS, METH_O, METH_NOARGS:
+	default:
+		return 0, fmt.Errorf("%s: NewFunction takes METH_VARARGS, METH_O or METH_NOARGS, got %#x: %w", name, flags, ErrValueError)
+	}
+	return p.newTrampolineFunction(name, flags, fn)
+}
+
+// NewFunctionWithKeywords is NewFunction for a METH_VARARGS|METH_KEYWORDS function.
+func (p *PythonLib) NewFunctionWithKeywords(name string, fn PyCFunctionWithKeywords) (PyObject, error) {
+	return p.newTrampolineFunction(name, METH_VARARGS|METH_KEYWORDS, fn)
+}
+
+// NewFunctionFastWithKeywords is NewFunction for a METH_FASTCALL|METH_KEYWORDS function.
+func (p *PythonLib) NewFunctionFastWithKeywords(name string, fn PyCFunctionFastWithKeywords) (PyObject, error) {
+	return p.newTrampolineFunction(name, METH_FASTCALL|METH_KEYWORDS, fn)
+}
+
+// NewCallable returns a new reference to a Python callable that calls the Go closure fn with
+// its positional arguments, so Go code can be passed where Python expects a callback, such as
+// threading.Thread(target=...), map() or a GUI event handler.  The arguments are borrowed
+// references valid during the call, and the result must be a new reference, or 0 for None.
+// The closure is released with the callable.
+//
+//	clicked, err := lib.NewCallable(func(args ...PyObject) (PyObject, error) {
+//		fmt.Println("clicked")
+//		return 0, nil
+//	})
+func (p *PythonLib) NewCallable(fn func(args ...PyObject) (PyObject, error)) (PyObject, error) {
+	return p.NewFunctionFastWithKeywords("go_callable", func(_ PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error) {
+		if nargs != len(args) {
+			return 0, fmt.Errorf("go_callable() takes no keyword arguments: %w", ErrTypeError)
+		}
+		return fn(args...)
+	})
+}
+
+func (p *PythonLib) newTrampolineFunction(name string, flags int, fn any) (PyObject, error) {
+	t := &p.trampolines
+	t.once.Do(func() { t.err = p.initTrampolines() })
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	meth := t.varargs
+	switch flags {
+	case METH_VARARGS | METH_KEYWORDS:
+		meth = t.keywords
+	case METH_FASTCALL | METH_KEYWORDS:
+		meth = t.fast
+	}
+	def, err := p.newMethodDef(name, meth, flags)
+	if err != nil {
+		return 0, err
+	}
+
+	handle := t.funcs.add(fn)
+	capsule := PyObject(p.Invoke("PyCapsule_New", handle, t.name, t.destructor))
+	if capsule == 0 {
+		t.funcs.remove(handle)
+		p.freeMethodDef(def)
+		return 0, p.FetchError()
+	}
+	// from here on the capsule destructor releases the handle and the definition
+	if int32(p.Invoke("PyCapsule_SetContext", uintptr(capsule), def)) != 0 {
+		p.DecRef(capsule)
+		t.funcs.remove(handle)
+		p.freeMethodDef(def)
+		return 0, p.FetchError()
+	}
+	f := PyObject(p.Invoke("PyCFunction_NewEx", def, uintptr(capsule), 0))
+	p.DecRef(capsule)
+	if f == 0 {
+		return 0, p.FetchError()
+	}
+	return f, nil
+}
+
+// newMethodDef allocates a PyMethodDef in C memory, for a function object created outside
+// of a method table.
+func (p *PythonLib) newMethodDef(name string, meth uintptr, flags int) (uintptr, error) {
+	layout := &p.CTags.PyStructs.PyMethodDef
+	def := p.Invoke("PyMem_Calloc", 1, uintptr(layout.Size))
+	if def == 0 {
+		return 0, fmt.Errorf("%s: could not allocate the method definition: %w", name, ErrMemoryError)
+	}
+	*(*uintptr)(unsafe.Pointer(def + uintptr(layout.GetMemberOffset("ml_name")))) = p.StrToPtr(name)
+	*(*uintptr)(unsafe.Pointer(def + uintptr(layout.GetMemberOffset("ml_meth")))) = meth
+	*(*int32)(unsafe.Pointer(def + uintptr(layout.GetMemberOffset("ml_flags")))) = int32(flags)
+	return def, nil
+}
+
+// freeMethodDef frees a definition allocated by newMethodDef.
+func (p *PythonLib) freeMethodDef(def uintptr) {
+	layout := &p.CTags.PyStructs.PyMethodDef
+	p.FreeString(*(*uintptr)(unsafe.Pointer(def + uintptr(layout.GetMemberOffset("ml_name")))))
+	p.Invoke("PyMem_Free", def)
+}
+
+// initTrampolines creates the trampolines and the capsule destructor.
+func (p *PythonLib) initTrampolines() error {
+	t := &p.trampolines
+	var err error
+	if t.getPointer, err = OpenSymbol(p.DLL, "PyCapsule_GetPointer"); err != nil {
+		return err
+	}
+	if t.getContext, err = OpenSymbol(p.DLL, "PyCapsule_GetContext"); err != nil {
+		return err
+	}
+	t.name = p.StrToPtr("kindalib.function")
+
+	t.varargs = purego.NewCallback(func(self uintptr, args uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+		fn, err := p.trampolineTarget(self)
+		if err != nil {
+			return p.RaiseError(err)
+		}
+		return p.callbackResult(fn.(PyCFunction)(0, PyObject(args)))
+	})
+	t.keywords = purego.NewCallback(func(self uintptr, args uintptr, kwargs uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+		fn, err := p.trampolineTarget(self)
+		if err != nil {
+			return p.RaiseError(err)
+		}
+		return p.callbackResult(fn.(PyCFunctionWithKeywords)(0, PyObject(args), PyObject(kwargs)))
+	})
+	t.fast = purego.NewCallback(func(self uintptr, args uintptr, nargs int, kwnames uintptr) (retv uintptr) {
+		defer p.recoverCallback(&retv, 0)
+		fn, err := p.trampolineTarget(self)
+		if err != nil {
+			return p.RaiseError(err)
+		}
+		argv := p.vectorView(args, nargs, kwnames)
+		return p.callbackResult(fn.(PyCFunctionFastWithKeywords)(0, argv, nargs, PyObject(kwnames)))
+	})
+	t.destructor = purego.NewCallback(func(capsule uintptr) {
+		var ignored uintptr
+		defer p.recoverCallback(&ignored, 0)
+		handle, _, _ := purego.SyscallN(t.getPointer, capsule, t.name)
+		t.funcs.remove(handle)
+		if def, _, _ := purego.SyscallN(t.getContext, capsule); def != 0 {
+			p.freeMethodDef(def)
+		}
+	})
+	return nil
+}
+
+// trampolineTarget finds the Go function of a function object from its self.
+func (p *PythonLib) trampolineTarget(self uintptr) (any, error) {
+	t := &p.trampolines
+	handle, _, _ := purego.SyscallN(t.getPointer, self, t.name)
+	if handle == 0 {
+		return nil, p.FetchError()
+	}
+	fn, ok := t.funcs.get(handle)
+	if !ok {
+		return nil, fmt.Errorf("function was released: %w", ErrSystemError)
+	}
+	return fn, nil
+}
+
+// methodTarget is an entry of the method table of a module or class.
+type methodTarget interface {
+	call(self PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error)
+}
+
+// methodTrampoline returns the callback of entry i of the method tables of modules and
+// classes, for the calling convention flags: METH_NOARGS, METH_O or
+// METH_FASTCALL|METH_KEYWORDS.  The callback finds the table from its self, the module or
+// the instance, so a callback per index serves every module and class.
+func (p *PythonLib) methodTrampoline(i int, flags int) uintptr {
+	t := &p.trampolines
+	t.methodsMu.Lock()
+	defer t.methodsMu.Unlock()
+
+	kind := 2
+	switch flags {
+	case METH_NOARGS:
+		kind = 0
+	case METH_O:
+		kind = 1
+	}
+	for n := len(t.methods[kind]); n <= i; n++ {
+		t.methods[kind] = append(t.methods[kind], p.newMethodTrampoline(n, flags))
+	}
+	return t.methods[kind][i]
+}
+
+func (p *PythonLib) newMethodTrampoline(i int, flags int) uintptr {
+	switch flags {
+	case METH_NOARGS:
+		return p.NewCallback(func(self PyObject, _ PyObject) (PyObject, error) {
+			fn, err := p.methodTarget(self, i)
+			if err != nil {
+				return 0, err
+			}
+			return fn.call(self, nil, 0, 0)
+		})
+	case METH_O:
+		return p.NewCallback(func(self PyObject, arg PyObject) (PyObject, error) {
+			fn, err := p.methodTarget(self, i)
+			if err != nil {
+				return 0, err
+			}
+			return fn.call(self, []PyObject{arg}, 1, 0)
+		})
+	}
+	return p.NewCallbackFastWithKeywords(func(self PyObject, args []PyObject, nargs int, kwnames PyObject) (PyObject, error) {
+		fn, err := p.methodTarget(self, i)
+		if err != nil {
+			return 0, err
+		}
+		return fn.call(self, args, nargs, kwnames)
+	})
+}
+
+// methodTarget finds entry i of the method table of self, a module defined by a
+// ModuleBuilder or an instance of a class defined with NewClass.
+func (p *PythonLib) methodTarget(self PyObject, i int) (methodTarget, error) {
+	if uintptr(p.typeOf(self)) == p.PyData["PyModule_Type"] {
+		b, err := p.moduleBuilderOf(self)
+		if err != nil {
+			return nil, err
+		}
+		return b.funcs[i].fn, nil
+	}
+	c := p.instanceClass(self)
+	if c == nil {
+		return nil, p.notInstance(self)
+	}
+	if i < len(c.methods) {
+		return c.methods[i].fn, nil
+	}
+	return c.special[i-len(c.methods)], nil
+}
@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// PyType_Slot ids from Include/typeslots.h, used with PyType_FromSpec
+const (
+	Py_bf_getbuffer               = 1
+	Py_bf_releasebuffer           = 2
+	Py_mp_ass_subscript           = 3
+	Py_mp_length                  = 4
+	Py_mp_subscript               = 5
+	Py_nb_absolute                = 6
+	Py_nb_add                     = 7
+	Py_nb_and                     = 8
+	Py_nb_bool                    = 9
+	Py_nb_divmod                  = 10
+	Py_nb_float                   = 11
+	Py_nb_floor_divide            = 12
+	Py_nb_index                   = 13
+	Py_nb_inplace_add             = 14
+	Py_nb_inplace_and             = 15
+	Py_nb_inplace_floor_divide    = 16
+	Py_nb_inplace_lshift          = 17
+	Py_nb_inplace_multiply        = 18
+	Py_nb_inplace_or              = 19
+	Py_nb_inplace_power           = 20
+	Py_nb_inplace_remainder       = 21
+	Py_nb_inplace_rshift          = 22
+	Py_nb_inplace_subtract        = 23
+	Py_nb_inplace_true_divide     = 24
+	Py_nb_inplace_xor             = 25
+	Py_nb_int                     = 26
+	Py_nb_invert                  = 27
+	Py_nb_lshift                  = 28
+	Py_nb_multiply                = 29
+	Py_nb_negative                = 30
+	Py_nb_or                      = 31
+	Py_nb_positive                = 32
+	Py_nb_power                   = 33
+	Py_nb_remainder               = 34
+	Py_nb_rshift                  = 35
+	Py_nb_subtract                = 36
+	Py_nb_true_divide             = 37
+	Py_nb_xor                     = 38
+	Py_sq_ass_item                = 39
+	Py_sq_concat                  = 40
+	Py_sq_contains                = 41
+	Py_sq_inplace_concat          = 42
+	Py_sq_inplace_repeat          = 43
+	Py_sq_item                    = 44
+	Py_sq_length                  = 45
+	Py_sq_repeat                  = 46
+	Py_tp_alloc                   = 47
+	Py_tp_base                    = 48
+	Py_tp_bases                   = 49
+	Py_tp_call                    = 50
+	Py_tp_clear                   = 51
+	Py_tp_dealloc                 = 52
+	Py_tp_del                     = 53
+	Py_tp_descr_get               = 54
+	Py_tp_descr_set               = 55
+	Py_tp_doc                     = 56
+	Py_tp_getattr                 = 57
+	Py_tp_getattro                = 58
+	Py_tp_hash                    = 59
+	Py_tp_init                    = 60
+	Py_tp_is_gc                   = 61
+	Py_tp_iter                    = 62
+	Py_tp_iternext                = 63
+	Py_tp_methods                 = 64
+	Py_tp_new                     = 65
+	Py_tp_repr                    = 66
+	Py_tp_richcompare             = 67
+	Py_tp_setattr                 = 68
+	Py_tp_setattro                = 69
+	Py_tp_str                     = 70
+	Py_tp_traverse                = 71
+	Py_tp_members                 = 72
+	Py_tp_getset                  = 73
+	Py_tp_free                    = 74
+	Py_nb_matrix_multiply         = 75
+	Py_nb_inplace_matrix_multiply = 76
+	Py_am_await                   = 77
+	Py_am_aiter                   = 78
+	Py_am_anext                   = 79
+	Py_tp_finalize                = 80
+	Py_am_send                    = 81
+)
+
+// PyType_Slot is a single entry of the slots array of a PyType_Spec.
+type PyType_Slot struct {
+	Slot  int
+	PFunc uintptr
+}
+
+// pyTypeSpecStruct mirrors the C PyType_Spec struct, which is part of the stable ABI
+type pyTypeSpecStruct struct {
+	name      uintptr
+	basicsize int32
+	itemsize  int32
+	flags     uint32
+	slots     uintptr
+}
+
+// pyTypeSlotStruct mirrors the C PyType_Slot struct
+type pyTypeSlotStruct struct {
+	slot  int32
+	pfunc uintptr
+}
+
+// NewTypeFromSpec creates a heap type with PyType_FromSpecWithBases.  The spec and slot
+// arrays are only read during the call, but the name is referenced by the type for its whole
+// lifetime on some versions, so it is allocated once and never freed.  bases may be 0 to
+// derive from object.
+func (p *PythonLib) NewTypeFromSpec(name string, basicsize int, itemsize int, flags uintptr, slots []PyType_Slot, bases PyObject) (PyObject, error) {
+	return p.NewTypeFromModuleAndSpec(0, name, basicsize, itemsize, flags, slots, bases)
+}
+
+// NewTypeFromModuleAndSpec creates a heap type with PyType_FromModuleAndSpec, associating it
+// with module so its methods can find the module with PyType_GetModule.  module may be 0 for
+// a type without a module, see NewTypeFromSpec.
+func (p *PythonLib) NewTypeFromModuleAndSpec(module PyObject, name string, basicsize int, itemsize int, flags uintptr, slots []PyType_Slot, bases PyObject) (PyObject, error) {
+	// the slots array is terminated by a {0, NULL} entry
+	slotsize := unsafe.Sizeof(pyTypeSlotStruct{})
+	slotbuf := p.Invoke("PyMem_Calloc", uintptr(len(slots)+1), slotsize)
+	defer p.Invoke("PyMem_Free", slotbuf)
+	cslots := unsafe.Slice((*pyTypeSlotStruct)(unsafe.Pointer(slotbuf)), len(slots)+1)
+	for i, s := range slots {
+		cslots[i] = pyTypeSlotStruct{slot: int32(s.Slot), pfunc: s.PFunc}
+	}
+
+	specbuf := p.Invoke("PyMem_Calloc", 1, unsafe.Sizeof(pyTypeSpecStruct{}))
+	defer p.Invoke("PyMem_Free", specbuf)
+	spec := (*pyTypeSpecStruct)(unsafe.Pointer(specbuf))
+	spec.name = p.StrToPtr(name)
+	spec.basicsize = int32(basicsize)
+	spec.itemsize = int32(itemsize)
+	spec.flags = uint32(flags)
+	spec.slots = slotbuf
+
+	var t PyObject
+	if module != 0 {
+		t = PyObject(p.Invoke("PyType_FromModuleAndSpec", uintptr(module), specbuf, uintptr(bases)))
+	} else {
+		t = PyObject(p.Invoke("PyType_FromSpecWithBases", specbuf, uintptr(bases)))
+	}
+	if t == 0 {
+		return 0, p.FetchError()
+	}
+	return t, nil
+}
+
+// GetTypeSlot returns the function pointer stored in a slot of a type, e.g. Py_tp_free.
+func (p *PythonLib) GetTypeSlot(t PyObject, slot int) uintptr {
+	return p.Invoke("PyType_GetSlot", uintptr(t), uintptr(slot))
+}
+
+// FreeHeapObject releases the memory of an instance of a heap type from its tp_dealloc, with
+// the tp_free of the instance's actual type, and drops the reference the instance held on
+// that type.
+func (p *PythonLib) FreeHeapObject(self PyObject) {
+	t := p.typeOf(self)
+	free := p.GetTypeSlot(t, Py_tp_free)
+	purego.SyscallN(free, uintptr(self))
+	p.DecRef(t)
+}
+
+// PyObjectHeadSize returns the size of a PyObject header, the basicsize of a type with no
+// instance data.
+func (p *PythonLib) PyObjectHeadSize() int {
+	return p.CTags.PyStructs.PyObject.Size
+}
@@ -148,7 +148,7 @@ func main() {
 	// Create the moduledef object and create the module from that def
 	moduledef := lib.NewPyModuleDef("example_module", "Example module with Go callback", &meth)
 
-	module := lib.Invoke("PyModule_Create2", moduledef.GetBuffer(), 3)
+	module := lib.Invoke("PyModule_Create2", moduledef.GetBuffer(), pylib.PYTHON_API_VERSION)
 	fmt.Printf("Created module: %v\n", module)
 
 	// create a semaphore out
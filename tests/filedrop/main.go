@@ -6,14 +6,12 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/ebitengine/purego"
 	kinda "github.com/richinsley/kinda/pkg"
 	pylib "github.com/richinsley/kindalib/pkg"
 )
 
-// example_module.file_dropped(wx.ICON_INFORMATION)
+// file_dropped is a Go closure set by the Go program
 var quote_str string = `
-import example_module
 import sys
 from PyQt5.QtWidgets import QApplication, QWidget, QVBoxLayout, QLabel
 from PyQt5.QtCore import Qt
@@ -45,7 +43,7 @@ class DropArea(QWidget):
         for f in files:
             print(f'Dropped file: {f}')
             # self.label.setText(f'Dropped: {f}')
-            example_module.file_dropped(f)
+            file_dropped(f)
 
 app = QApplication(sys.argv)
 ex = DropArea()
@@ -54,20 +52,6 @@ app.exec_()
 `
 var lib pylib.IPythonLib
 
-func file_dropped_cb(p1 uintptr, p2 uintptr) uintptr {
-	fmt.Println("File Dropped!")
-	// Ensure the result is a Python string
-	// PyUnicode_Check is a MACRO.  We need to create these manually
-	if lib.Invoke("PyUnicode_Check", p2) == 0 {
-		fmt.Println("Not a string")
-		return lib.GetPyNone()
-	}
-
-	s := lib.Invoke("PyUnicode_AsUTF8", p2)
-	fmt.Printf("File dropped: %s\n", lib.PtrToStr(s))
-	return lib.GetPyNone()
-}
-
 // func init() {
 // 	// Run main on the startup thread to satisfy the requirement
 // 	// that Main runs on that thread.
@@ -101,33 +85,39 @@ func main() {
 
 	lib.Init("file_drop")
 
-	// create the method object
-	meth := lib.NewPyMethodDefArray(1)
-	fmt.Printf("Created method object with size: %d\n", meth.PyConfig.Size)
-
-	// create a callback function
-	var f func(uintptr, uintptr) uintptr = file_dropped_cb
-	goCallback := purego.NewCallback(f)
-
-	// set the buttonClickPtr to meth.SetMethodDef
-	meth.SetMethodDef(0, "file_dropped", uintptr(goCallback), pylib.METH_VARARGS)
-
-	// Create the moduledef object and create the module from that def
-	moduledef := lib.NewPyModuleDef("example_module", "Example module with Go callback", &meth)
-
-	module := lib.Invoke("PyModule_Create2", moduledef.GetBuffer(), 3)
-	fmt.Printf("Created module: %v\n", module)
-
-	// Add the module to sys.modules
-	sys_modules := lib.Invoke("PyImport_GetModuleDict")
-	lib.Invoke("PyDict_SetItemString", sys_modules, lib.StrToPtr("example_module"), module)
-	lib.Invoke("Py_DecRef", module)
+	// the Go closure the drop handler calls with each file
+	dropped, err := lib.NewCallable(func(args ...pylib.PyObject) (pylib.PyObject, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("file_dropped takes a path: %w", pylib.ErrTypeError)
+		}
+		path, err := lib.AsString(args[0])
+		if err != nil {
+			return 0, err
+		}
+		fmt.Printf("File dropped: %s\n", path)
+		return 0, nil
+	})
+	if err != nil {
+		fmt.Printf("Error creating callable: %v\n", err)
+		return
+	}
+	mainModule := lib.ImportModule("__main__")
+	if mainModule == 0 {
+		lib.DecRef(dropped)
+		fmt.Printf("Error importing __main__: %v\n", lib.FetchError())
+		return
+	}
+	err = lib.SetAttrString(mainModule, "file_dropped", dropped)
+	lib.DecRef(mainModule)
+	lib.DecRef(dropped)
+	if err != nil {
+		fmt.Printf("Error setting file_dropped: %v\n", err)
+		return
+	}
 
 	// Run the Python code
 	lib.Invoke("PyRun_SimpleString", lib.StrToPtr(quote_str))
 
-	// purego.UnrefCallbackFnPtr(&f)
-
 	// free the memory
 
 	fmt.Println("Done")
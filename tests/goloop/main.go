@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	kinda "github.com/richinsley/kinda/pkg"
+	pylib "github.com/richinsley/kindalib/pkg"
+)
+
+// the coroutines run on the loop created by NewGoEventLoop, which the Go program sets as loop
+var script = `
+import os, socket, threading, time
+
+async def socketpair_echo():
+    a, b = socket.socketpair()
+    a.setblocking(False)
+    b.setblocking(False)
+    await loop.sock_sendall(a, b"ping")
+    data = await loop.sock_recv(b, 4)
+    await loop.sock_sendall(b, data.upper())
+    reply = await loop.sock_recv(a, 4)
+    a.close()
+    b.close()
+    return reply
+
+async def pipe_reader():
+    # os.pipe file descriptors are blocking, so the netpoller doesn't watch them
+    r, w = os.pipe()
+    got = loop.create_future()
+    def readable():
+        loop.remove_reader(r)
+        got.set_result(os.read(r, 100))
+    loop.add_reader(r, readable)
+    threading.Timer(0.5, os.write, (w, b"written by a thread")).start()
+    start = time.process_time()
+    data = await got
+    cpu = time.process_time() - start
+    os.close(r)
+    os.close(w)
+    return data, cpu
+
+async def threadsafe():
+    fut = loop.create_future()
+    def wake():
+        time.sleep(0.1)
+        loop.call_soon_threadsafe(fut.set_result, "woken by call_soon_threadsafe")
+    threading.Thread(target=wake).start()
+    return await fut
+
+print("socketpair echo:", loop.run_until_complete(socketpair_echo()))
+data, cpu = loop.run_until_complete(pipe_reader())
+print("pipe reader:", data, "CPU while waiting: %.3fs" % cpu)
+if cpu > 0.25:
+    print("the loop spun while waiting for the pipe")
+print("threadsafe:", loop.run_until_complete(threadsafe()))
+loop.close()
+`
+
+func init() {
+	// the interpreter is initialized on, and the loop runs on, the main thread
+	runtime.LockOSThread()
+}
+
+func main() {
+	// Specify the binary folder to place micromamba in
+	cwd, _ := os.Getwd()
+	rootDirectory := filepath.Join(cwd, "..", "micromamba")
+	fmt.Println("Creating Kinda repo at: ", rootDirectory)
+	version := "3.10"
+	env, err := kinda.CreateEnvironment("myenv"+version, rootDirectory, version, "conda-forge", kinda.ShowVerbose)
+	if err != nil {
+		fmt.Printf("Error creating environment: %v\n", err)
+		return
+	}
+	fmt.Printf("Created environment: %s\n", env.Name)
+
+	lib, err := pylib.NewPythonLib(env)
+	if err != nil {
+		fmt.Printf("Error creating library: %v\n", err)
+		return
+	}
+	lib.Init("goloop")
+
+	loop, err := lib.NewGoEventLoop()
+	if err != nil {
+		fmt.Printf("Error creating the event loop: %v\n", err)
+		return
+	}
+	mainModule := lib.ImportModule("__main__")
+	if mainModule == 0 {
+		lib.DecRef(loop)
+		fmt.Printf("Error importing __main__: %v\n", lib.FetchError())
+		return
+	}
+	err = lib.SetAttrString(mainModule, "loop", loop)
+	lib.DecRef(mainModule)
+	lib.DecRef(loop)
+	if err != nil {
+		fmt.Printf("Error setting loop: %v\n", err)
+		return
+	}
+
+	lib.Invoke("PyRun_SimpleString", lib.StrToPtr(script))
+}
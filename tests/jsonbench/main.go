@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	kinda "github.com/richinsley/kinda/pkg"
+	pylib "github.com/richinsley/kindalib/pkg"
+)
+
+// record is one element of the benchmark document
+type record struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Score  float64  `json:"score"`
+	Tags   []string `json:"tags"`
+	Active bool     `json:"active"`
+	Extra  *string  `json:"extra"`
+}
+
+func init() {
+	// the interpreter is initialized on, and holds the GIL for, the main thread
+	runtime.LockOSThread()
+}
+
+func main() {
+	// Specify the binary folder to place micromamba in
+	cwd, _ := os.Getwd()
+	rootDirectory := filepath.Join(cwd, "..", "micromamba")
+	fmt.Println("Creating Kinda repo at: ", rootDirectory)
+	version := "3.10"
+	env, err := kinda.CreateEnvironment("myenv"+version, rootDirectory, version, "conda-forge", kinda.ShowVerbose)
+	if err != nil {
+		fmt.Printf("Error creating environment: %v\n", err)
+		return
+	}
+	fmt.Printf("Created environment: %s\n", env.Name)
+
+	lib, err := pylib.NewPythonLib(env)
+	if err != nil {
+		fmt.Printf("Error creating library: %v\n", err)
+		return
+	}
+	lib.Init("jsonbench")
+
+	records := make([]record, 20000)
+	for i := range records {
+		records[i] = record{ID: i, Name: fmt.Sprintf("user%d", i), Score: float64(i) * 1.5, Tags: []string{"a", "b"}, Active: i%2 == 0}
+	}
+	doc, err := lib.FromJSONValue(records)
+	if err != nil {
+		fmt.Printf("Error building the document: %v\n", err)
+		return
+	}
+	data, err := lib.ToJSON(doc)
+	if err != nil {
+		fmt.Printf("Error encoding the document: %v\n", err)
+		return
+	}
+	fmt.Printf("Document: %d records, %d bytes\n", len(records), len(data))
+
+	jsonmod := lib.ImportModule("json")
+	if jsonmod == 0 {
+		fmt.Printf("Error importing json: %v\n", lib.FetchError())
+		return
+	}
+
+	// testing.Benchmark runs the benchmark on another goroutine, which takes the GIL that
+	// this thread gives up meanwhile
+	report := func(name string, bench func(b *testing.B)) {
+		var r testing.BenchmarkResult
+		lib.AllowThreads(func() {
+			r = testing.Benchmark(func(b *testing.B) {
+				lib.WithGIL(func() error {
+					bench(b)
+					return nil
+				})
+			})
+		})
+		fmt.Printf("%-12s %10.2f ms/op\n", name, float64(r.NsPerOp())/1e6)
+	}
+	report("FromJSON", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			obj, err := lib.FromJSON(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			lib.DecRef(obj)
+		}
+	})
+	// the document starts in Go, so json.loads pays for copying it into a bytes object
+	report("json.loads", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pydata := lib.NewBytes(data)
+			lib.DecRef(lib.CallMethod(jsonmod, "loads", pydata))
+			lib.DecRef(pydata)
+		}
+	})
+	report("ToJSON", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := lib.ToJSON(doc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	report("json.dumps", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lib.DecRef(lib.CallMethod(jsonmod, "dumps", doc))
+		}
+	})
+}
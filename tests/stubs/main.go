@@ -0,0 +1,117 @@
+package main
+
+//go:generate go run . -pyi ./stubs
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	kinda "github.com/richinsley/kinda/pkg"
+	pylib "github.com/richinsley/kindalib/pkg"
+)
+
+// Sample is returned to Python as a dict, declared as a TypedDict in the stub
+type Sample struct {
+	Name   string    `py:"name"`
+	Values []float64 `py:"values"`
+	Unit   *string   `py:"unit"`
+}
+
+// Counter backs the Counter class
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Len() int { return c.n }
+
+// declare declares the modules the program exposes, for both the stubs and the interpreter
+func declare(lib pylib.IPythonLib) *pylib.ModuleBuilder {
+	counter := lib.NewClass("Counter", func(start *int) (*Counter, error) {
+		c := &Counter{}
+		if start != nil {
+			c.n = *start
+		}
+		return c, nil
+	}, "start").
+		Doc("Counts up from start.").
+		MethodDoc("incr", "Adds by to the counter and returns it.", func(c *Counter, by int) int {
+			c.n += by
+			return c.n
+		}, "by").
+		Property("value", func(c *Counter) int { return c.n }, nil)
+
+	return lib.NewModule("measure").
+		Doc("Measurements computed in Go.").
+		Const("VERSION", "1.0").
+		FuncDoc("mean", "Returns the mean of the values.", func(values []float64) (float64, error) {
+			if len(values) == 0 {
+				return 0, fmt.Errorf("mean of no values: %w", pylib.ErrValueError)
+			}
+			var sum float64
+			for _, v := range values {
+				sum += v
+			}
+			return sum / float64(len(values)), nil
+		}, "values").
+		Func("sample", func(name string, values ...float64) Sample {
+			return Sample{Name: name, Values: values}
+		}).
+		Class(counter)
+}
+
+var code = `
+import inspect
+import measure
+
+print(inspect.signature(measure.mean), measure.mean.__doc__)
+print(inspect.signature(measure.Counter))
+print(measure.mean([1, 2, 3]), measure.sample("a", 1.5, 2.5))
+c = measure.Counter(start=2)
+c.incr(by=3)
+print(c.value, len(c))
+`
+
+func main() {
+	pyi := flag.String("pyi", "", "write the .pyi stubs of the modules to this directory and exit")
+	flag.Parse()
+
+	// Specify the binary folder to place micromamba in
+	cwd, _ := os.Getwd()
+	rootDirectory := filepath.Join(cwd, "..", "micromamba")
+	fmt.Println("Creating Kinda repo at: ", rootDirectory)
+	version := "3.10"
+	env, err := kinda.CreateEnvironment("myenv"+version, rootDirectory, version, "conda-forge", kinda.ShowVerbose)
+	if err != nil {
+		fmt.Printf("Error creating environment: %v\n", err)
+		return
+	}
+	fmt.Printf("Created environment: %s\n", env.Name)
+
+	lib, err := pylib.NewPythonLib(env)
+	if err != nil {
+		fmt.Printf("Error creating library: %v\n", err)
+		return
+	}
+	module := declare(lib)
+
+	// the stubs only need the declarations, so they are written before the interpreter starts
+	if *pyi != "" {
+		if err := pylib.WriteStubs(*pyi, module); err != nil {
+			fmt.Printf("Error writing stubs: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	lib.Init("stubs")
+	m, err := module.Register()
+	if err != nil {
+		fmt.Printf("Error registering module: %v\n", err)
+		return
+	}
+	lib.DecRef(m)
+
+	lib.Invoke("PyRun_SimpleString", lib.StrToPtr(code))
+}
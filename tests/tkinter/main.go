@@ -7,33 +7,22 @@ import (
 	"path/filepath"
 	"runtime"
 
-	"github.com/ebitengine/purego"
 	kinda "github.com/richinsley/kinda/pkg"
 	pylib "github.com/richinsley/kindalib/pkg"
 )
 
 var quote_str string = `
 import tkinter as tk
-import example_module
-
-def button_click():
-    example_module.button_clicked()
-    print("Button clicked! (Python function called)")
 
 window = tk.Tk()
-window.title("Tkinter Example with C++ Callback")
+window.title("Tkinter Example with Go Callback")
 
-button = tk.Button(window, text="Click Me", command=button_click)
+# button_clicked is a Go closure set by the Go program
+button = tk.Button(window, text="Click Me", command=button_clicked)
 button.pack()
 
 window.mainloop()
 `
-var pynone uintptr
-
-func button_cb(p1 uintptr, p2 uintptr) uintptr {
-	fmt.Println("Button clicked!")
-	return pynone
-}
 
 func init() {
 	// Run main on the startup thread to satisfy the requirement
@@ -69,38 +58,33 @@ func main() {
 	fmt.Printf("PyLong_AsLong: %d\n", rval)
 	lib.Invoke("Py_DecRef", pylong)
 
-	// set the PyNone pointer
-	// pynone is a global static PyObject* that is used to return None from C functions
-	// returning just NULL is not enough, you need to return Py_None
-	pynone = lib.GetPyNone()
-
-	// create the method object
-	meth := lib.NewPyMethodDefArray(1)
-	fmt.Printf("Created method object with size: %d\n", meth.PyConfig.Size)
-
-	// create a callback function
-	var f func(uintptr, uintptr) uintptr = button_cb
-	// goCallback := purego.NewCallbackFnPtr(&f)
-	goCallback := purego.NewCallback(f)
-
-	// set the buttonClickPtr to meth.SetMethodDef
-	meth.SetMethodDef(0, "button_clicked", uintptr(goCallback), pylib.METH_VARARGS)
-
-	// Create the moduledef object and create the module from that def
-	moduledef := lib.NewPyModuleDef("example_module", "Example module with Go callback", &meth)
-
-	module := lib.Invoke("PyModule_Create2", moduledef.GetBuffer(), 3)
-	fmt.Printf("Created module: %v\n", module)
-
-	// Add the module to sys.modules
-	sys_modules := lib.Invoke("PyImport_GetModuleDict")
-	lib.Invoke("PyDict_SetItemString", sys_modules, lib.StrToPtr("example_module"), module)
-	lib.Invoke("Py_DecRef", module)
+	// hand the button a Go closure as its command
+	clicks := 0
+	clicked, err := lib.NewCallable(func(args ...pylib.PyObject) (pylib.PyObject, error) {
+		clicks++
+		fmt.Printf("Button clicked %d times!\n", clicks)
+		return 0, nil
+	})
+	if err != nil {
+		fmt.Printf("Error creating callable: %v\n", err)
+		return
+	}
+	mainModule := lib.ImportModule("__main__")
+	if mainModule == 0 {
+		lib.DecRef(clicked)
+		fmt.Printf("Error importing __main__: %v\n", lib.FetchError())
+		return
+	}
+	err = lib.SetAttrString(mainModule, "button_clicked", clicked)
+	lib.DecRef(mainModule)
+	lib.DecRef(clicked)
+	if err != nil {
+		fmt.Printf("Error setting button_clicked: %v\n", err)
+		return
+	}
 
 	// Run the Python code
 	lib.Invoke("PyRun_SimpleString", lib.StrToPtr(quote_str))
 
-	// purego.UnrefCallbackFnPtr(&f)
-
 	select {}
 }